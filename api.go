@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type apiCheckResult struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Source     string    `json:"source"`
+	State      string    `json:"state"`
+	Success    bool      `json:"success"`
+	Streak     int64     `json:"streak"`
+	LastRun    time.Time `json:"last_run"`
+	LastError  string    `json:"error,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	LastOutput string    `json:"output"`
+
+	Remediation *apiRemediation `json:"remediation,omitempty"`
+}
+
+type apiRemediation struct {
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	Successes   int64     `json:"successes"`
+	LastRun     time.Time `json:"last_run"`
+	LastError   string    `json:"error,omitempty"`
+	LastOutput  string    `json:"output"`
+}
+
+func newAPICheckResult(checkID string, cr checkResult) apiCheckResult {
+	state, _ := cr.state()
+
+	var rem *apiRemediation
+	if cr.Check.Remediate != nil {
+		rem = &apiRemediation{
+			Attempts:    cr.Remediation.Attempts,
+			MaxAttempts: cr.Check.Remediate.MaxAttempts,
+			Successes:   cr.Remediation.Successes,
+			LastRun:     cr.Remediation.LastRun,
+			LastError:   cr.Remediation.LastError,
+			LastOutput:  cr.Remediation.LastOutput,
+		}
+	}
+
+	return apiCheckResult{
+		ID:         checkID,
+		Name:       cr.Check.Name,
+		Source:     cr.Check.Source,
+		State:      state,
+		Success:    cr.IsSuccess,
+		Streak:     cr.Streak,
+		LastRun:    cr.LastRun,
+		LastError:  cr.LastError,
+		Reason:     cr.Reason,
+		LastOutput: cr.LastOutput,
+
+		Remediation: rem,
+	}
+}
+
+func registerAPIRoutes(r *mux.Router) {
+	if cfg.APIToken == "" {
+		return
+	}
+
+	r.HandleFunc("/checks/run", requireAPIToken(handleRunAllChecks)).Methods(http.MethodPost)
+	r.HandleFunc("/checks/{id}/run", requireAPIToken(handleRunCheck)).Methods(http.MethodPost)
+	r.HandleFunc("/admin/reload", requireAPIToken(handleReload)).Methods(http.MethodPost)
+}
+
+func requireAPIToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.APIToken)) != 1 {
+			logger.WithFields(logFields{"path": r.URL.Path, "remote_addr": r.RemoteAddr}).Warnf("Rejected API request with invalid token")
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(res, r)
+	}
+}
+
+// shouldWait reads the `wait` query parameter which defaults to true
+func shouldWait(r *http.Request) (bool, error) {
+	wait := r.URL.Query().Get("wait")
+	if wait == "" {
+		return true, nil
+	}
+	return strconv.ParseBool(wait)
+}
+
+func handleRunCheck(res http.ResponseWriter, r *http.Request) {
+	checkID := mux.Vars(r)["id"]
+
+	wait, err := shouldWait(r)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Invalid value for wait: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := getCheck(checkID); !ok {
+		http.Error(res, fmt.Sprintf("Check %q is not defined", checkID), http.StatusNotFound)
+		return
+	}
+
+	logger.WithFields(logFields{"check_id": checkID, "remote_addr": r.RemoteAddr}).Infof("Check execution requested through API")
+
+	run := runCheck(checkID, 0)
+
+	if !wait {
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	<-run.done
+	writeJSON(res, newAPICheckResult(checkID, run.result))
+}
+
+func handleRunAllChecks(res http.ResponseWriter, r *http.Request) {
+	wait, err := shouldWait(r)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Invalid value for wait: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	logger.WithFields(logFields{"remote_addr": r.RemoteAddr}).Infof("Execution of all checks requested through API")
+
+	runs := map[string]*checkRun{}
+	for _, id := range getCheckIDs() {
+		runs[id] = runCheck(id, 0)
+	}
+
+	if !wait {
+		res.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	results := []apiCheckResult{}
+	for id, run := range runs {
+		<-run.done
+		results = append(results, newAPICheckResult(id, run.result))
+	}
+
+	writeJSON(res, results)
+}
+
+func handleReload(res http.ResponseWriter, r *http.Request) {
+	if err := reloadChecks("API request"); err != nil {
+		logger.WithFields(logFields{"error": err}).Errorf("Unable to reload checks")
+		http.Error(res, fmt.Sprintf("Unable to reload checks: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(res http.ResponseWriter, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(v)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// setupTestChecks replaces the defined checks and resets all results to
+// execute checks without a running daemon, it returns the registry the
+// metrics are recorded in
+func setupTestChecks(t *testing.T, defs map[string]checkCommand) *prometheus.Registry {
+	cfg.MetricsNamespace = "test"
+	cfg.MetricsLabels = nil
+	cfg.MetricsBuckets = nil
+	cfg.MetricsRuntime = false
+	cfg.MetricsLegacy = false
+	reg, err := newMetricsRegistry()
+	if err != nil {
+		t.Fatalf("Creating registry failed: %s", err)
+	}
+
+	cfg.CheckInterval = time.Minute
+	cfg.UnhealthyThreshold = 5
+	cfg.Shell = "bash"
+	cfg.OutputWaitDelay = time.Second
+	cfg.CheckLogStderr = checkLogSinkNone
+	cfg.HistorySize = 0
+	cfg.StateFile = ""
+	cfg.WebhookURLs = nil
+	webhookQueues = nil
+
+	checkLogDisabled = map[string]bool{"STDERR": true, "STDOUT": true}
+
+	checksLock.Lock()
+	checks = defs
+	checksLock.Unlock()
+
+	checkResultsLock.Lock()
+	checkResults = map[string]*checkResult{}
+	lastVerdictHealthy = true
+	checkResultsLock.Unlock()
+
+	return reg
+}
+
+func newTestAPIRouter() *mux.Router {
+	cfg.APIToken = "secret"
+
+	r := mux.NewRouter()
+	registerAPIRoutes(r)
+	return r
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{"ok": {Name: "ok", Command: commandLine{Script: "true"}}})
+	r := newTestAPIRouter()
+
+	for token, code := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/checks/ok/run", nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("Expected status %d for token %q, got %d", code, token, rec.Code)
+		}
+	}
+}
+
+func TestAPIRunCheck(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{
+		"ok":   {Name: "Always passing", Command: commandLine{Script: "echo hello"}},
+		"fail": {Name: "Always failing", Command: commandLine{Script: "echo broken; exit 1"}},
+	})
+	r := newTestAPIRouter()
+
+	for path, code := range map[string]int{
+		"/checks/unknown/run":       http.StatusNotFound,
+		"/checks/ok/run?wait=maybe": http.StatusBadRequest,
+		"/checks/ok/run?wait=false": http.StatusAccepted,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("Expected status %d for %s, got %d", code, path, rec.Code)
+		}
+	}
+
+	// Wait for the execution started without waiting to finish
+	runningChecksLock.Lock()
+	run := runningChecks["ok"]
+	runningChecksLock.Unlock()
+	if run != nil {
+		<-run.done
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/checks/fail/run", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var res apiCheckResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Response is no valid JSON: %s", err)
+	}
+	if res.ID != "fail" || res.Success || res.State != "CRIT" || res.Streak != 1 || res.LastOutput != "broken\n" {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/checks/run", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var results []apiCheckResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Response is no valid JSON: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected results of both checks, got %+v", results)
+	}
+	for _, res := range results {
+		if res.ID == "fail" && res.Streak != 2 {
+			t.Errorf("Expected streak of failing check to be 2, got %d", res.Streak)
+		}
+		if res.ID == "ok" && (!res.Success || res.Streak != 2) {
+			t.Errorf("Unexpected result of passing check: %+v", res)
+		}
+	}
+}
+
+func TestRunCheckDoesNotOverlap(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{"slow": {Name: "slow", Command: commandLine{Script: "sleep 0.5"}}})
+
+	first := runCheck("slow", 0)
+	second := runCheck("slow", 0)
+	if first != second {
+		t.Fatalf("Check was executed concurrently to itself")
+	}
+
+	<-first.done
+	if first.result.Streak != 1 {
+		t.Errorf("Expected a single execution, got streak %d", first.result.Streak)
+	}
+
+	if third := runCheck("slow", 0); third == first {
+		t.Errorf("Finished execution was returned for a new run")
+	} else {
+		<-third.done
+	}
+}
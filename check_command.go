@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// killWaitTimeout is the time to wait for a process to exit after
+	// sending SIGKILL before abandoning it
+	killWaitTimeout = 5 * time.Second
+
+	reasonTimeout     = "timed out"
+	reasonOutputLimit = "output limit exceeded"
+	reasonMemoryLimit = "memory limit exceeded"
+)
+
+// commandLine is either a script executed through a shell or a list of
+// arguments executed directly
+type commandLine struct {
+	Script string
+	Args   []string
+}
+
+func (c *commandLine) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Script); err == nil {
+		return nil
+	}
+
+	if err := unmarshal(&c.Args); err != nil {
+		return errors.New("command needs to be a string or a list of strings")
+	}
+	return nil
+}
+
+func (c commandLine) isEmpty() bool {
+	return c.Script == "" && (len(c.Args) == 0 || c.Args[0] == "")
+}
+
+// shellArgs returns the arguments to execute the script in the given
+// shell which can be given by name or path. Scripts executed by bash
+// fail on errors inside pipes.
+func shellArgs(shell, script string) []string {
+	path := shell
+	if !strings.Contains(shell, "/") {
+		path = "/bin/" + shell
+	}
+
+	switch filepath.Base(shell) {
+	case "bash":
+		return []string{path, "-e", "-o", "pipefail", "-c", script}
+	case "sh":
+		return []string{path, "-e", "-c", script}
+	default:
+		return []string{shell, "-c", script}
+	}
+}
+
+// runCheckCommand executes the check and waits for it to finish or the
+// context to be cancelled. The returned reason is set if the check was
+// stopped because it exceeded one of its limits.
+func runCheckCommand(ctx context.Context, checkID string, check checkCommand, output io.Writer) (string, error) {
+	limits, err := parseCheckLimits(check)
+	if err != nil {
+		return "", err
+	}
+
+	cmd, err := newCheckCmd(check)
+	if err != nil {
+		return "", err
+	}
+
+	var limitedOutput *outputLimiter
+	if limits.maxOutput > 0 {
+		limitedOutput = newOutputLimiter(limits.maxOutput, func() {
+			logger.WithFields(logFields{"check_id": checkID}).Warnf("Check exceeded its output limit, killing it")
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+		output = limitedOutput.wrap(output)
+	}
+
+	// Output is forwarded line by line, remaining partial lines are
+	// written after the check exited
+	limiter := newLineRateLimiter(cfg.CheckLogRate, cfg.CheckLogBurst)
+
+	stderr := output
+	if stderrLog := newCheckOutputLogger(checkID, "STDERR", limiter); stderrLog != nil {
+		defer stderrLog.Flush()
+		stderr = io.MultiWriter(stderrLog, output)
+	}
+
+	stdout := output
+	if stdoutLog := newCheckOutputLogger(checkID, "STDOUT", limiter); stdoutLog != nil {
+		defer stdoutLog.Flush()
+		stdout = io.MultiWriter(stdoutLog, output)
+	}
+
+	// The pipes are handled here instead of letting exec copy the output
+	// as cmd.Wait would otherwise block until every process having the
+	// pipes open exited, including processes which left the process group
+	pipes, err := attachOutputPipes(cmd, stdout, stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if err = cmd.Start(); err != nil {
+		pipes.close()
+		return "", err
+	}
+	pipes.startCopy()
+
+	limited, limitErr := applyCheckLimits(checkID, cmd.Process.Pid, limits)
+	if limitErr != nil {
+		// Do not let the check run without its limits
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if limited != nil {
+		defer limited.cleanup()
+	}
+
+	reason := ""
+	cmdDone := make(chan error, 1)
+	go func(cmdDone chan error, cmd *exec.Cmd) { cmdDone <- cmd.Wait() }(cmdDone, cmd)
+
+	select {
+	case err = <-cmdDone:
+	case <-ctx.Done():
+		reason = reasonTimeout
+		err = terminateProcessGroup(checkID, cmd.Process.Pid, cmdDone)
+	}
+
+	pipes.wait(cfg.OutputWaitDelay)
+
+	switch {
+	case limitErr != nil:
+		return "", fmt.Errorf("Unable to apply limits: %s", limitErr)
+	case reason != "":
+		// Timeout takes precedence over other reasons
+	case limitedOutput != nil && limitedOutput.exceeded():
+		reason = reasonOutputLimit
+	case limited != nil && limited.memoryExceeded():
+		reason = reasonMemoryLimit
+	}
+
+	if reason != "" && err == nil {
+		err = errors.New(reason)
+	}
+
+	return reason, err
+}
+
+// terminateProcessGroup asks all processes in the group to terminate and
+// kills them if they did not exit within the grace period. If the process
+// still does not exit (for example because it is stuck in an
+// uninterruptible sleep) it is abandoned.
+func terminateProcessGroup(checkID string, pid int, cmdDone chan error) error {
+	logger.WithFields(logFields{"check_id": checkID}).Warnf("Execution of check timed out, sending SIGTERM")
+	syscall.Kill(-pid, syscall.SIGTERM)
+
+	select {
+	case err := <-cmdDone:
+		return err
+	case <-time.After(cfg.KillGracePeriod):
+	}
+
+	logger.WithFields(logFields{"check_id": checkID, "grace_period": cfg.KillGracePeriod}).Warnf("Check did not exit within grace period, sending SIGKILL")
+	syscall.Kill(-pid, syscall.SIGKILL)
+
+	select {
+	case err := <-cmdDone:
+		return err
+	case <-time.After(killWaitTimeout):
+	}
+
+	logger.WithFields(logFields{"check_id": checkID}).Errorf("Check did not exit after SIGKILL, abandoning it")
+	return errors.New(reasonTimeout)
+}
+
+// outputPipes connects the output of a command to writers through pipes
+// which can be abandoned when processes keep them open
+type outputPipes struct {
+	readers []*os.File
+	writers []*os.File
+	targets []io.Writer
+
+	copyDone sync.WaitGroup
+}
+
+func attachOutputPipes(cmd *exec.Cmd, stdout, stderr io.Writer) (*outputPipes, error) {
+	p := &outputPipes{}
+
+	for _, target := range []io.Writer{stdout, stderr} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.readers = append(p.readers, r)
+		p.writers = append(p.writers, w)
+		p.targets = append(p.targets, target)
+	}
+
+	cmd.Stdout, cmd.Stderr = p.writers[0], p.writers[1]
+	return p, nil
+}
+
+// startCopy closes the write ends inherited by the started process and
+// starts copying the output to the targets
+func (p *outputPipes) startCopy() {
+	for _, w := range p.writers {
+		w.Close()
+	}
+	p.writers = nil
+
+	for i := range p.readers {
+		p.copyDone.Add(1)
+		go func(r *os.File, target io.Writer) {
+			defer p.copyDone.Done()
+			io.Copy(target, r)
+		}(p.readers[i], p.targets[i])
+	}
+}
+
+// wait waits for the remaining output for the given delay and closes the
+// pipes afterwards
+func (p *outputPipes) wait(delay time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		p.copyDone.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(delay):
+	}
+
+	p.close()
+}
+
+func (p *outputPipes) close() {
+	for _, f := range append(p.readers, p.writers...) {
+		f.Close()
+	}
+}
+
+// newCheckCmd creates the command to execute the check including its
+// environment, working directory and credentials
+func newCheckCmd(check checkCommand) (*exec.Cmd, error) {
+	args := check.Command.Args
+	if args == nil {
+		shell := check.Shell
+		if shell == "" {
+			shell = cfg.Shell
+		}
+		args = shellArgs(shell, check.Command.Script)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+
+	// Enable process groups in to order to be able to kill a whole group
+	// instead of a single process
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	cmd.Dir = check.Workdir
+
+	env := map[string]string{}
+	if check.ClearEnv {
+		env["PATH"] = defaultPath
+	} else {
+		for _, kv := range os.Environ() {
+			parts := strings.SplitN(kv, "=", 2)
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	if check.User != "" || check.Group != "" {
+		cred, u, err := checkCredential(check)
+		if err != nil {
+			return nil, err
+		}
+		cmd.SysProcAttr.Credential = cred
+
+		if u != nil {
+			env["HOME"] = u.HomeDir
+			env["USER"] = u.Username
+			env["LOGNAME"] = u.Username
+		}
+	}
+
+	for k, v := range check.Env {
+		env[k] = v
+	}
+
+	cmd.Env = []string{}
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	sort.Strings(cmd.Env)
+
+	return cmd, nil
+}
+
+// checkCredential resolves the user and group of the check which can be
+// given as names or numeric IDs. If no group is given the primary group
+// of the user is used, if no user is given the check runs as the user of
+// the daemon.
+func checkCredential(check checkCommand) (*syscall.Credential, *user.User, error) {
+	var (
+		cred = &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		}
+		u *user.User
+	)
+
+	if check.User != "" {
+		var err error
+		if u, err = lookupUser(check.User); err != nil {
+			return nil, nil, err
+		}
+
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid uid %q of user %q", u.Uid, check.User)
+		}
+		cred.Uid = uint32(uid)
+
+		switch {
+		case u.Gid != "":
+			gid, err := strconv.ParseUint(u.Gid, 10, 32)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid gid %q of user %q", u.Gid, check.User)
+			}
+			cred.Gid = uint32(gid)
+		case check.Group == "":
+			// Do not guess the group of users without passwd entry
+			return nil, nil, fmt.Errorf("User %q has no passwd entry, the group needs to be set", check.User)
+		}
+
+		if groupIDs, err := u.GroupIds(); err == nil {
+			for _, g := range groupIDs {
+				if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+					cred.Groups = append(cred.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+
+	if check.Group != "" {
+		gid, err := lookupGroupID(check.Group)
+		if err != nil {
+			return nil, nil, err
+		}
+		cred.Gid = gid
+	}
+
+	return cred, u, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		// Numeric users without passwd entry are allowed, they have no
+		// primary group
+		return &user.User{Uid: name, Username: name, HomeDir: "/"}, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find user %q: %s", name, err)
+	}
+	return u, nil
+}
+
+func lookupGroupID(name string) (uint32, error) {
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find group %q: %s", name, err)
+	}
+
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid gid %q of group %q", g.Gid, name)
+	}
+	return uint32(gid), nil
+}
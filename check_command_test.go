@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewCheckCmdEnvironment(t *testing.T) {
+	os.Setenv("TEST_INHERITED", "yes")
+	defer os.Unsetenv("TEST_INHERITED")
+
+	cfg.Shell = "bash"
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+
+	cmd, err := newCheckCmd(checkCommand{
+		Command: commandLine{Script: "echo $TEST_INHERITED $FOO; pwd"},
+		Env:     map[string]string{"FOO": "bar"},
+		Workdir: dir,
+	})
+	if err != nil {
+		t.Fatalf("Creating command failed: %s", err)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Executing command failed: %s", err)
+	}
+	if expect := "yes bar\n" + dir + "\n"; string(out) != expect {
+		t.Errorf("Unexpected output %q, expected %q", out, expect)
+	}
+
+	cmd, err = newCheckCmd(checkCommand{
+		Command:  commandLine{Args: []string{"env"}},
+		Env:      map[string]string{"FOO": "bar"},
+		ClearEnv: true,
+	})
+	if err != nil {
+		t.Fatalf("Creating command failed: %s", err)
+	}
+	if expect := []string{"FOO=bar", "PATH=" + defaultPath}; !reflect.DeepEqual(cmd.Env, expect) {
+		t.Errorf("Unexpected cleared environment: %v", cmd.Env)
+	}
+	if !reflect.DeepEqual(cmd.Args, []string{"env"}) {
+		t.Errorf("Unexpected arguments: %v", cmd.Args)
+	}
+}
+
+func TestCheckCredential(t *testing.T) {
+	uid := strconv.Itoa(os.Getuid())
+
+	cred, u, err := checkCredential(checkCommand{User: uid})
+	if err != nil {
+		t.Fatalf("Resolving current user failed: %s", err)
+	}
+	if strconv.Itoa(int(cred.Uid)) != uid || u == nil || u.Uid != uid {
+		t.Errorf("Unexpected credential for current user: %+v", cred)
+	}
+
+	cred, _, err = checkCredential(checkCommand{Group: "4242"})
+	if err != nil {
+		t.Fatalf("Resolving group failed: %s", err)
+	}
+	if int(cred.Uid) != os.Getuid() || cred.Gid != 4242 {
+		t.Errorf("Unexpected credential for group only: %+v", cred)
+	}
+
+	if _, _, err = checkCredential(checkCommand{User: "4242424"}); err == nil || !strings.Contains(err.Error(), "no passwd entry") {
+		t.Errorf("Expected numeric user without passwd entry and group to be rejected, got %v", err)
+	}
+
+	cred, _, err = checkCredential(checkCommand{User: "4242424", Group: "4242"})
+	if err != nil {
+		t.Fatalf("Resolving numeric user with group failed: %s", err)
+	}
+	if cred.Uid != 4242424 || cred.Gid != 4242 {
+		t.Errorf("Unexpected credential for numeric user: %+v", cred)
+	}
+
+	if _, _, err = checkCredential(checkCommand{User: "no-such-user-elb"}); err == nil {
+		t.Errorf("Expected unknown user to be rejected")
+	}
+	if _, _, err = checkCredential(checkCommand{Group: "no-such-group-elb"}); err == nil {
+		t.Errorf("Expected unknown group to be rejected")
+	}
+}
+
+func TestShellArgs(t *testing.T) {
+	for shell, expect := range map[string][]string{
+		"bash":                {"/bin/bash", "-e", "-o", "pipefail", "-c", "true"},
+		"/bin/bash":           {"/bin/bash", "-e", "-o", "pipefail", "-c", "true"},
+		"/usr/local/bin/bash": {"/usr/local/bin/bash", "-e", "-o", "pipefail", "-c", "true"},
+		"sh":                  {"/bin/sh", "-e", "-c", "true"},
+		"/usr/bin/sh":         {"/usr/bin/sh", "-e", "-c", "true"},
+		"zsh":                 {"zsh", "-c", "true"},
+		"/usr/bin/python3":    {"/usr/bin/python3", "-c", "true"},
+	} {
+		if args := shellArgs(shell, "true"); !reflect.DeepEqual(args, expect) {
+			t.Errorf("Unexpected arguments for shell %s: %v", shell, args)
+		}
+	}
+}
+
+func TestCommandLineUnmarshalYAML(t *testing.T) {
+	defs := map[string]checkCommand{}
+	err := yaml.Unmarshal([]byte(`
+script:
+  name: script
+  command: test -f /etc/hosts
+argv:
+  name: argv
+  command: ["/usr/bin/test", "-f", "/etc/hosts"]
+`), &defs)
+	if err != nil {
+		t.Fatalf("Parsing commands failed: %s", err)
+	}
+
+	if c := defs["script"].Command; c.Script != "test -f /etc/hosts" || c.Args != nil {
+		t.Errorf("Unexpected script command: %+v", c)
+	}
+	if c := defs["argv"].Command; c.Script != "" || !reflect.DeepEqual(c.Args, []string{"/usr/bin/test", "-f", "/etc/hosts"}) {
+		t.Errorf("Unexpected argv command: %+v", c)
+	}
+
+	var c commandLine
+	if err := yaml.Unmarshal([]byte("{foo: bar}"), &c); err == nil {
+		t.Errorf("Expected map to be rejected as command")
+	}
+
+	for _, empty := range []commandLine{{}, {Args: []string{}}, {Args: []string{""}}} {
+		if !empty.isEmpty() {
+			t.Errorf("Expected %+v to be empty", empty)
+		}
+	}
+}
+
+func TestTerminateTimedOutCheck(t *testing.T) {
+	cfg.KillGracePeriod = 500 * time.Millisecond
+	cfg.OutputWaitDelay = 100 * time.Millisecond
+	checkLogDisabled = map[string]bool{"STDERR": true, "STDOUT": true}
+
+	for name, tc := range map[string]struct {
+		script     string
+		output     string
+		minRuntime time.Duration
+		maxRuntime time.Duration
+	}{
+		"exits on SIGTERM": {
+			script:     "trap 'echo terminated; exit 3' TERM; echo started; while true; do sleep 0.05; done",
+			output:     "started\nterminated\n",
+			maxRuntime: 400 * time.Millisecond,
+		},
+		"ignores SIGTERM": {
+			script:     "trap '' TERM; echo started; while true; do sleep 0.05; done",
+			output:     "started\n",
+			minRuntime: 600 * time.Millisecond,
+			maxRuntime: 2 * time.Second,
+		},
+	} {
+		// Discard the shell reporting the terminated sleep
+		script := "exec 2>/dev/null; " + tc.script
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		output := newOutputTail(maxCapturedOutput)
+
+		start := time.Now()
+		reason, err := runCheckCommand(ctx, "timeout", checkCommand{
+			Command: commandLine{Args: []string{"/bin/sh", "-c", script}},
+		}, output)
+		runtime := time.Since(start)
+		cancel()
+
+		if reason != reasonTimeout || err == nil {
+			t.Errorf("%s: Expected check to time out, got reason %q and error %v", name, reason, err)
+		}
+		if output.String() != tc.output {
+			t.Errorf("%s: Unexpected output %q", name, output.String())
+		}
+		if runtime < tc.minRuntime || runtime > tc.maxRuntime {
+			t.Errorf("%s: Unexpected runtime %s", name, runtime)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ioprioClassRealtime   = 1
+	ioprioClassBestEffort = 2
+	ioprioClassIdle       = 3
+)
+
+// checkLimits contains the parsed resource limits of a check, zero
+// values mean no limit is applied
+type checkLimits struct {
+	maxMemory   int64
+	cpuQuota    float64
+	nice        int
+	ioprioClass int
+	ioprioLevel int
+	maxOutput   int64
+}
+
+func (l checkLimits) needsCgroup() bool {
+	return l.maxMemory > 0 || l.cpuQuota > 0
+}
+
+func parseCheckLimits(check checkCommand) (checkLimits, error) {
+	var (
+		l   = checkLimits{nice: check.Nice}
+		err error
+	)
+
+	if l.maxMemory, err = parseByteSize(check.MaxMemory); err != nil {
+		return l, fmt.Errorf("Invalid max-memory: %s", err)
+	}
+
+	if l.maxOutput, err = parseByteSize(check.MaxOutput); err != nil {
+		return l, fmt.Errorf("Invalid max-output: %s", err)
+	}
+
+	if check.CPUQuota != "" {
+		quota := strings.TrimSuffix(check.CPUQuota, "%")
+		if l.cpuQuota, err = strconv.ParseFloat(quota, 64); err != nil || l.cpuQuota <= 0 {
+			return l, fmt.Errorf("Invalid cpu-quota %q", check.CPUQuota)
+		}
+		if quota != check.CPUQuota {
+			l.cpuQuota /= 100
+		}
+	}
+
+	if l.nice < -20 || l.nice > 19 {
+		return l, fmt.Errorf("Invalid nice %d: must be between -20 and 19", l.nice)
+	}
+
+	if check.IONice != "" {
+		parts := strings.SplitN(check.IONice, ":", 2)
+		switch parts[0] {
+		case "realtime":
+			l.ioprioClass = ioprioClassRealtime
+		case "best-effort":
+			l.ioprioClass = ioprioClassBestEffort
+		case "idle":
+			l.ioprioClass = ioprioClassIdle
+		default:
+			return l, fmt.Errorf("Invalid ionice class %q", parts[0])
+		}
+
+		if len(parts) == 2 {
+			if l.ioprioLevel, err = strconv.Atoi(parts[1]); err != nil || l.ioprioLevel < 0 || l.ioprioLevel > 7 {
+				return l, fmt.Errorf("Invalid ionice level %q: must be between 0 and 7", parts[1])
+			}
+		} else if l.ioprioClass != ioprioClassIdle {
+			l.ioprioLevel = 4
+		}
+	}
+
+	if l.needsCgroup() && cfg.CgroupParent == "" {
+		return l, fmt.Errorf("max-memory and cpu-quota require --cgroup-parent to be set")
+	}
+
+	return l, nil
+}
+
+// parseByteSize parses sizes like 512, 64k, 256M or 1G using binary units
+func parseByteSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch strings.ToUpper(size[len(size)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+
+	v, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("Invalid size %q", size)
+	}
+	return v * multiplier, nil
+}
+
+// outputLimiter counts the bytes written through all writers wrapped by
+// it and calls onExceed once the limit is exceeded. Further output is
+// discarded.
+type outputLimiter struct {
+	limit    int64
+	onExceed func()
+
+	written     int64
+	isExceeded  bool
+	writtenLock sync.Mutex
+}
+
+func newOutputLimiter(limit int64, onExceed func()) *outputLimiter {
+	return &outputLimiter{limit: limit, onExceed: onExceed}
+}
+
+func (o *outputLimiter) wrap(w io.Writer) io.Writer {
+	return limitedWriter{limiter: o, wrapped: w}
+}
+
+func (o *outputLimiter) exceeded() bool {
+	o.writtenLock.Lock()
+	defer o.writtenLock.Unlock()
+
+	return o.isExceeded
+}
+
+type limitedWriter struct {
+	limiter *outputLimiter
+	wrapped io.Writer
+}
+
+func (l limitedWriter) Write(in []byte) (int, error) {
+	o := l.limiter
+
+	o.writtenLock.Lock()
+	if o.isExceeded {
+		o.writtenLock.Unlock()
+		return len(in), nil
+	}
+
+	allowed := o.limit - o.written
+	o.written += int64(len(in))
+	if int64(len(in)) > allowed {
+		o.isExceeded = true
+		o.writtenLock.Unlock()
+
+		l.wrapped.Write(in[:allowed])
+		o.onExceed()
+		return len(in), nil
+	}
+	o.writtenLock.Unlock()
+
+	return l.wrapped.Write(in)
+}
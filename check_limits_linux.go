@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	cpuPeriod = 100000
+
+	ioprioWhoPgrp    = 2
+	ioprioClassShift = 13
+
+	// cgroupMoveAttempts limits how often the process group is scanned
+	// for processes not yet moved into the cgroup
+	cgroupMoveAttempts = 10
+)
+
+// limitedProcess holds the cgroup a check process was moved into
+type limitedProcess struct {
+	cgroup string
+}
+
+// applyCheckLimits applies the limits to the freshly started process
+// group of the check. As the limits are applied after the process was
+// started there is a small window in which the process is not limited,
+// processes forked in that window are moved into the cgroup as well and
+// processes forked afterwards inherit the limits.
+func applyCheckLimits(checkID string, pid int, l checkLimits) (*limitedProcess, error) {
+	if l.nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PGRP, pid, l.nice); err != nil {
+			return nil, fmt.Errorf("Unable to set nice: %s", err)
+		}
+	}
+
+	if l.ioprioClass != 0 {
+		prio := l.ioprioClass<<ioprioClassShift | l.ioprioLevel
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoPgrp, uintptr(pid), uintptr(prio)); errno != 0 {
+			return nil, fmt.Errorf("Unable to set ionice: %s", errno)
+		}
+	}
+
+	if !l.needsCgroup() {
+		return nil, nil
+	}
+
+	cgroup := filepath.Join(cfg.CgroupParent, cgroupName(checkID, pid))
+	if err := os.Mkdir(cgroup, 0755); err != nil {
+		return nil, fmt.Errorf("Unable to create cgroup: %s", err)
+	}
+	p := &limitedProcess{cgroup: cgroup}
+
+	if l.maxMemory > 0 {
+		if err := p.write("memory.max", strconv.FormatInt(l.maxMemory, 10)); err != nil {
+			p.cleanup()
+			return nil, err
+		}
+	}
+
+	if l.cpuQuota > 0 {
+		if err := p.write("cpu.max", fmt.Sprintf("%d %d", int64(l.cpuQuota*cpuPeriod), cpuPeriod)); err != nil {
+			p.cleanup()
+			return nil, err
+		}
+	}
+
+	if err := p.moveProcessGroup(pid); err != nil {
+		p.cleanup()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// cgroupName returns the name of the cgroup for an execution of the
+// check, characters not safe to use in a path are replaced to prevent
+// check IDs from escaping the parent cgroup
+func cgroupName(checkID string, pid int) string {
+	safeID := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, checkID)
+
+	return fmt.Sprintf("check-%s-%d", safeID, pid)
+}
+
+// moveProcessGroup moves all processes of the group into the cgroup. The
+// group is scanned again until no new processes are found as processes
+// not yet moved might fork in the meantime.
+func (p *limitedProcess) moveProcessGroup(pgid int) error {
+	moved := map[int]bool{}
+
+	for attempt := 0; attempt < cgroupMoveAttempts; attempt++ {
+		pids, err := processGroupMembers(pgid)
+		if err != nil {
+			return fmt.Errorf("Unable to list processes of check: %s", err)
+		}
+
+		found := false
+		for _, pid := range pids {
+			if moved[pid] {
+				continue
+			}
+			found = true
+
+			if err := p.write("cgroup.procs", strconv.Itoa(pid)); err != nil {
+				if _, statErr := os.Stat(fmt.Sprintf("/proc/%d", pid)); os.IsNotExist(statErr) {
+					// Process exited in the meantime
+					continue
+				}
+				return err
+			}
+			moved[pid] = true
+		}
+
+		if !found {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unable to move all processes of check into cgroup")
+}
+
+// processGroupMembers returns the IDs of all processes in the group
+func processGroupMembers(pgid int) ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	pids := []int{}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		stat, err := ioutil.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			// Process exited in the meantime
+			continue
+		}
+
+		// The command name might contain spaces and parentheses, the
+		// fields following it are: state ppid pgrp
+		i := strings.LastIndexByte(string(stat), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[i+1:]))
+		if len(fields) > 2 && fields[2] == strconv.Itoa(pgid) {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids, nil
+}
+
+func (p *limitedProcess) write(file, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(p.cgroup, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("Unable to set %s: %s", file, err)
+	}
+	return nil
+}
+
+// memoryExceeded reports whether the OOM killer was invoked in the cgroup
+func (p *limitedProcess) memoryExceeded() bool {
+	f, err := os.Open(filepath.Join(p.cgroup, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanup removes the cgroup which is only possible after all processes
+// inside it exited, so leftover processes are killed first
+func (p *limitedProcess) cleanup() {
+	p.write("cgroup.kill", "1")
+
+	for i := 0; i < 10; i++ {
+		if err := os.Remove(p.cgroup); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestCgroupName(t *testing.T) {
+	for id, expect := range map[string]string{
+		"docker_running": "check-docker_running-42",
+		"disk-space":     "check-disk-space-42",
+		"a/../../escape": "check-a_______escape-42",
+		"..":             "check-__-42",
+	} {
+		if name := cgroupName(id, 42); name != expect {
+			t.Errorf("Unexpected cgroup name for %q: %s", id, name)
+		}
+		if strings.ContainsAny(cgroupName(id, 42), "/") {
+			t.Errorf("Cgroup name for %q contains a path separator", id)
+		}
+	}
+}
+
+func TestProcessGroupMembers(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 5 & sleep 5 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Starting process failed: %s", err)
+	}
+	defer func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	}()
+
+	var pids []int
+	for i := 0; i < 50; i++ {
+		var err error
+		if pids, err = processGroupMembers(cmd.Process.Pid); err != nil {
+			t.Fatalf("Listing process group failed: %s", err)
+		}
+		if len(pids) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sort.Ints(pids)
+	if i := sort.SearchInts(pids, cmd.Process.Pid); len(pids) != 3 || i == len(pids) || pids[i] != cmd.Process.Pid {
+		t.Errorf("Expected shell and both children in process group, got %v", pids)
+	}
+}
+
+func TestOutputLimitKillsCheck(t *testing.T) {
+	cfg.KillGracePeriod = time.Second
+	cfg.OutputWaitDelay = 100 * time.Millisecond
+	checkLogDisabled = map[string]bool{"STDERR": true, "STDOUT": true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	output := newOutputTail(maxCapturedOutput)
+	start := time.Now()
+	reason, err := runCheckCommand(ctx, "output", checkCommand{
+		Command:   commandLine{Args: []string{"/bin/sh", "-c", "while true; do echo 0123456789; done"}},
+		MaxOutput: "1k",
+	}, output)
+
+	if reason != reasonOutputLimit || err == nil {
+		t.Errorf("Expected check to be killed for its output, got reason %q and error %v", reason, err)
+	}
+	if runtime := time.Since(start); runtime > 5*time.Second {
+		t.Errorf("Check was not killed when exceeding its output limit: runtime %s", runtime)
+	}
+	if len(output.String()) > 1024 {
+		t.Errorf("More output than the limit was captured: %d bytes", len(output.String()))
+	}
+}
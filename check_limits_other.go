@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+type limitedProcess struct{}
+
+func applyCheckLimits(checkID string, pid int, l checkLimits) (*limitedProcess, error) {
+	if l.needsCgroup() || l.nice != 0 || l.ioprioClass != 0 {
+		return nil, errors.New("Resource limits are only supported on linux")
+	}
+	return nil, nil
+}
+
+func (p *limitedProcess) memoryExceeded() bool { return false }
+
+func (p *limitedProcess) cleanup() {}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for size, expected := range map[string]int64{
+		"":     0,
+		"512":  512,
+		"64k":  64 * 1024,
+		"256M": 256 * 1024 * 1024,
+		"1G":   1024 * 1024 * 1024,
+	} {
+		v, err := parseByteSize(size)
+		if err != nil {
+			t.Fatalf("Size %q caused an error: %s", size, err)
+		}
+		if v != expected {
+			t.Fatalf("Size %q was parsed to %d, expected %d", size, v, expected)
+		}
+	}
+
+	for _, size := range []string{"M", "-5", "1.5G", "10T"} {
+		if _, err := parseByteSize(size); err == nil {
+			t.Fatalf("Invalid size %q did not cause an error", size)
+		}
+	}
+}
+
+func TestOutputLimiter(t *testing.T) {
+	var (
+		buf      = bytes.NewBuffer([]byte{})
+		exceeded int
+		ol       = newOutputLimiter(10, func() { exceeded++ })
+		w        = ol.wrap(buf)
+	)
+
+	w.Write([]byte("12345"))
+	if ol.exceeded() {
+		t.Fatalf("Limit was reported as exceeded after 5 bytes")
+	}
+
+	w.Write([]byte("67890abc"))
+	w.Write([]byte("def"))
+
+	if !ol.exceeded() || exceeded != 1 {
+		t.Fatalf("Limit exceed was not reported exactly once: %d", exceeded)
+	}
+
+	if buf.String() != "1234567890" {
+		t.Fatalf("Unexpected output passed through: %q", buf.String())
+	}
+}
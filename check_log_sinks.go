@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	checkLogSinkLog      = "log"
+	checkLogSinkNone     = "none"
+	checkLogSinkJournald = "journald"
+	checkLogSinkSyslog   = "syslog"
+
+	syslogDialTimeout = 5 * time.Second
+)
+
+// Severities used for the output of checks in journald and syslog
+var checkLogSeverity = map[string]int{
+	"STDERR": 4, // warning
+	"STDOUT": 6, // info
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// checkLogSink receives the lines of output of checks
+type checkLogSink interface {
+	writeLine(checkID, stream, line string) error
+}
+
+var (
+	// checkLogSinks contains the sink for each output stream, streams
+	// without entry are written to the daemon log
+	checkLogSinks = map[string]checkLogSink{}
+	// checkLogDisabled contains the streams not to forward at all
+	checkLogDisabled = map[string]bool{}
+)
+
+// initCheckLogSinks creates the sinks configured for the output streams
+// of the checks, streams using the same sink share its connection
+func initCheckLogSinks() error {
+	stdout := cfg.CheckLogStdout
+	if cfg.Verbose && stdout == checkLogSinkNone {
+		stdout = checkLogSinkLog
+	}
+
+	created := map[string]checkLogSink{}
+	for stream, kind := range map[string]string{"STDERR": cfg.CheckLogStderr, "STDOUT": stdout} {
+		switch kind {
+		case checkLogSinkLog:
+			continue
+		case checkLogSinkNone:
+			checkLogDisabled[stream] = true
+			continue
+		case checkLogSinkJournald, checkLogSinkSyslog:
+		default:
+			return fmt.Errorf("Unknown output sink %q for %s", kind, stream)
+		}
+
+		if _, ok := created[kind]; !ok {
+			var (
+				sink checkLogSink
+				err  error
+			)
+			if kind == checkLogSinkJournald {
+				sink, err = newJournaldSink(cfg.JournaldSocket)
+			} else {
+				sink, err = newSyslogSink(cfg.SyslogAddress, cfg.SyslogFacility, cfg.SyslogTag)
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to create %s sink: %s", kind, err)
+			}
+			created[kind] = sink
+		}
+
+		checkLogSinks[stream] = created[kind]
+	}
+
+	return nil
+}
+
+// journaldSink sends the lines using the native journald protocol to keep
+// the priority and identity of the check as fields of the entries
+type journaldSink struct {
+	conn       *net.UnixConn
+	identifier string
+}
+
+func newJournaldSink(socket string) (*journaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &journaldSink{conn: conn, identifier: cfg.SyslogTag}, nil
+}
+
+func (j *journaldSink) writeLine(checkID, stream, line string) error {
+	buf := new(bytes.Buffer)
+	writeJournaldField(buf, "MESSAGE", line)
+	writeJournaldField(buf, "PRIORITY", fmt.Sprintf("%d", checkLogSeverity[stream]))
+	writeJournaldField(buf, "SYSLOG_IDENTIFIER", j.identifier)
+	writeJournaldField(buf, "CHECK_ID", checkID)
+	writeJournaldField(buf, "STREAM", stream)
+
+	_, err := j.conn.Write(buf.Bytes())
+	return err
+}
+
+// writeJournaldField serializes a field, values containing newlines are
+// sent in the binary format prefixed with their length
+func writeJournaldField(buf *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", key, value)
+		return
+	}
+
+	buf.WriteString(key + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// syslogSink sends the lines as RFC5424 messages to a syslog server
+// using the check ID as MSGID
+type syslogSink struct {
+	network  string
+	address  string
+	facility int
+	appName  string
+	hostname string
+
+	conn net.Conn
+	lock sync.Mutex
+}
+
+// newSyslogSink creates a sink for an address like unix:///dev/log,
+// udp://logs:514 or tcp://logs:601
+func newSyslogSink(address, facility, appName string) (*syslogSink, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &syslogSink{network: u.Scheme, appName: syslogField(appName, 48)}
+	switch u.Scheme {
+	case "unix":
+		s.address = u.Path
+	case "udp", "tcp":
+		s.address = u.Host
+	default:
+		return nil, fmt.Errorf("Unsupported syslog network %q", u.Scheme)
+	}
+
+	var ok bool
+	if s.facility, ok = syslogFacilities[facility]; !ok {
+		return nil, fmt.Errorf("Unknown syslog facility %q", facility)
+	}
+
+	if s.hostname, err = os.Hostname(); err != nil {
+		return nil, err
+	}
+	s.hostname = syslogField(s.hostname, 255)
+
+	// The connection is retried on every write, an unavailable server
+	// must not prevent the daemon from starting
+	if err := s.connect(); err != nil {
+		logger.WithFields(logFields{"address": address, "error": err}).Warnf("Unable to connect to syslog")
+	}
+
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	var err error
+	if s.network == "unix" {
+		// The local syslog socket might be a datagram or a stream socket
+		if s.conn, err = net.DialTimeout("unixgram", s.address, syslogDialTimeout); err == nil {
+			return nil
+		}
+	}
+
+	s.conn, err = net.DialTimeout(s.network, s.address, syslogDialTimeout)
+	return err
+}
+
+func (s *syslogSink) format(checkID, stream, line string, now time.Time) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		s.facility*8+checkLogSeverity[stream],
+		now.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		s.hostname,
+		s.appName,
+		os.Getpid(),
+		syslogField(checkID, 32),
+		line,
+	)
+}
+
+func (s *syslogSink) writeLine(checkID, stream, line string) error {
+	msg := s.format(checkID, stream, line, time.Now())
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		// Reconnect once as stream connections might have been closed
+		if s.conn == nil || attempt > 0 {
+			if err = s.connect(); err != nil {
+				continue
+			}
+		}
+
+		if _, err = s.conn.Write(s.frame(msg)); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// frame prefixes the message with its length (octet counting, RFC6587)
+// on stream connections, datagrams contain exactly one message
+func (s *syslogSink) frame(msg string) []byte {
+	switch s.conn.RemoteAddr().Network() {
+	case "tcp", "unix":
+		return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+	default:
+		return []byte(msg)
+	}
+}
+
+// syslogField restricts a header field to printable ASCII of the given
+// maximum length as required by RFC5424
+func syslogField(in string, maxLen int) string {
+	out := []byte{}
+	for i := 0; i < len(in) && len(out) < maxLen; i++ {
+		if in[i] >= 33 && in[i] <= 126 {
+			out = append(out, in[i])
+		}
+	}
+	if len(out) == 0 {
+		return "-"
+	}
+	return string(out)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournaldSink(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Unable to listen on socket: %s", err)
+	}
+	defer conn.Close()
+
+	cfg.SyslogTag = "elb-instance-status"
+	sink, err := newJournaldSink(socket)
+	if err != nil {
+		t.Fatalf("Creating sink failed: %s", err)
+	}
+
+	if err := sink.writeLine("docker", "STDERR", "Cannot connect"); err != nil {
+		t.Fatalf("Writing line failed: %s", err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Reading datagram failed: %s", err)
+	}
+
+	expect := "MESSAGE=Cannot connect\nPRIORITY=4\nSYSLOG_IDENTIFIER=elb-instance-status\nCHECK_ID=docker\nSTREAM=STDERR\n"
+	if string(buf[:n]) != expect {
+		t.Errorf("Unexpected journald message:\n%q\nexpected:\n%q", buf[:n], expect)
+	}
+}
+
+func TestWriteJournaldFieldMultiline(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeJournaldField(buf, "MESSAGE", "a\nb")
+
+	expect := new(bytes.Buffer)
+	expect.WriteString("MESSAGE\n")
+	binary.Write(expect, binary.LittleEndian, uint64(3))
+	expect.WriteString("a\nb\n")
+
+	if !bytes.Equal(buf.Bytes(), expect.Bytes()) {
+		t.Errorf("Unexpected serialization: %q", buf.Bytes())
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer conn.Close()
+
+	sink, err := newSyslogSink("udp://"+conn.LocalAddr().String(), "local3", "eis")
+	if err != nil {
+		t.Fatalf("Creating sink failed: %s", err)
+	}
+
+	if err := sink.writeLine("docker", "STDOUT", "all fine"); err != nil {
+		t.Fatalf("Writing line failed: %s", err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Reading datagram failed: %s", err)
+	}
+
+	// local3 (19) * 8 + info (6) = 158
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<158>1 ") || !strings.HasSuffix(msg, " eis "+strconv.Itoa(os.Getpid())+" docker - all fine") {
+		t.Errorf("Unexpected syslog message: %q", msg)
+	}
+}
+
+func TestSyslogSinkTCPFraming(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		prefix, _ := r.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(prefix))
+		msg := make([]byte, n)
+		io.ReadFull(r, msg)
+		received <- prefix + string(msg)
+	}()
+
+	sink, err := newSyslogSink("tcp://"+l.Addr().String(), "daemon", "eis")
+	if err != nil {
+		t.Fatalf("Creating sink failed: %s", err)
+	}
+	if err := sink.writeLine("docker", "STDERR", "x"); err != nil {
+		t.Fatalf("Writing line failed: %s", err)
+	}
+
+	select {
+	case msg := <-received:
+		parts := strings.SplitN(msg, " ", 2)
+		if len(parts) != 2 || parts[0] != strconv.Itoa(len(parts[1])) || !strings.HasPrefix(parts[1], "<28>1 ") || !strings.HasSuffix(parts[1], " docker - x") {
+			t.Errorf("Message is not framed using octet counting: %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("No message received")
+	}
+}
+
+func TestSyslogSinkConfig(t *testing.T) {
+	for _, c := range []struct{ address, facility string }{
+		{"http://localhost", "daemon"},
+		{"udp://127.0.0.1:514", "unknown"},
+	} {
+		if _, err := newSyslogSink(c.address, c.facility, "eis"); err == nil {
+			t.Errorf("Expected config %v to be rejected", c)
+		}
+	}
+
+	if syslogField("my check\n", 32) != "mycheck" || syslogField("", 32) != "-" {
+		t.Errorf("Header fields are not sanitized")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron"
+	"golang.org/x/net/context"
+)
+
+// checkSlots limits the number of concurrently executed checks, it is
+// nil if the number is unlimited
+var checkSlots chan struct{}
+
+func initCheckSlots() {
+	if cfg.MaxConcurrentChecks > 0 {
+		checkSlots = make(chan struct{}, cfg.MaxConcurrentChecks)
+	}
+}
+
+// scheduleChecks starts the periodic execution of the checks. The start
+// is delayed by a random jitter to prevent a whole fleet of instances
+// started at the same time from executing their checks in lock-step.
+func scheduleChecks() {
+	if cfg.CheckJitter > 0 {
+		jitter := time.Duration(rand.Int63n(int64(cfg.CheckJitter)))
+		logger.WithFields(logFields{"duration": jitter}).Infof("Delaying check schedule")
+		time.Sleep(jitter)
+	}
+
+	c := cron.New()
+	c.AddFunc("@every "+cfg.CheckInterval.String(), spawnChecks)
+	c.Start()
+
+	spawnChecks()
+}
+
+// startDelay returns a random delay up to the splay configured for the
+// check or globally to prevent all checks from starting at once
+func (c checkCommand) startDelay() time.Duration {
+	splay := c.Splay
+	if splay == 0 {
+		splay = cfg.CheckSplay
+	}
+	if splay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(splay)))
+}
+
+// checkTimeout returns how long a single execution of a check may take
+// to finish before the next one is scheduled
+func checkTimeout() time.Duration {
+	return cfg.CheckInterval - time.Second
+}
+
+// validateSplay ensures the start of a check is not delayed into the
+// next scheduled execution
+func validateSplay(splay time.Duration) error {
+	if splay < 0 {
+		return fmt.Errorf("Splay %s must not be negative", splay)
+	}
+	if splay > 0 && splay >= checkTimeout() {
+		return fmt.Errorf("Splay %s must be shorter than the check interval %s minus 1s", splay, cfg.CheckInterval)
+	}
+	return nil
+}
+
+// waitForSlot waits for an execution slot to become free. It returns
+// false if the context was cancelled before.
+func waitForSlot(ctx context.Context) bool {
+	if checkSlots == nil {
+		return true
+	}
+
+	start := time.Now()
+	checkQueueDepth.Inc()
+	defer checkQueueDepth.Dec()
+
+	select {
+	case checkSlots <- struct{}{}:
+		checkQueueWait.Observe(time.Since(start).Seconds())
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func releaseSlot() {
+	if checkSlots != nil {
+		<-checkSlots
+	}
+}
+
+// currentResult returns the last registered result of the check
+func currentResult(checkID string) checkResult {
+	checkResultsLock.RLock()
+	defer checkResultsLock.RUnlock()
+
+	if cr, ok := checkResults[checkID]; ok {
+		return *cr
+	}
+
+	check, _ := getCheck(checkID)
+	return checkResult{Check: check}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateSplay(t *testing.T) {
+	cfg.CheckInterval = time.Minute
+
+	for splay, valid := range map[time.Duration]bool{
+		0:                true,
+		30 * time.Second: true,
+		58 * time.Second: true,
+		59 * time.Second: false,
+		2 * time.Minute:  false,
+		-1 * time.Second: false,
+	} {
+		if err := validateSplay(splay); (err == nil) != valid {
+			t.Errorf("Unexpected validation result for splay %s: %v", splay, err)
+		}
+	}
+
+	cfg.TemplateDefinitions = false
+	if _, err := parseChecks([]byte("slow: {name: slow, command: \"true\", splay: 5m}")); err == nil {
+		t.Errorf("Check with splay exceeding the interval was accepted")
+	}
+}
+
+func TestStartDelay(t *testing.T) {
+	cfg.CheckSplay = 0
+	if d := (checkCommand{}).startDelay(); d != 0 {
+		t.Errorf("Expected no delay without splay, got %s", d)
+	}
+
+	cfg.CheckSplay = time.Second
+	for i := 0; i < 100; i++ {
+		if d := (checkCommand{}).startDelay(); d < 0 || d >= time.Second {
+			t.Fatalf("Delay %s exceeds global splay", d)
+		}
+		if d := (checkCommand{Splay: 10 * time.Millisecond}).startDelay(); d < 0 || d >= 10*time.Millisecond {
+			t.Fatalf("Delay %s exceeds splay of the check", d)
+		}
+	}
+	cfg.CheckSplay = 0
+}
+
+func TestRunCheckTimeoutStartsAfterDelay(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{
+		"a": {Name: "a", Command: commandLine{Script: "sleep 0.4"}},
+		"b": {Name: "b", Command: commandLine{Script: "sleep 0.4"}},
+	})
+
+	// Each check may take 0.5s, started after a delay of 0.3s and waiting
+	// for the other check to release the single execution slot
+	cfg.CheckInterval = 1500 * time.Millisecond
+	cfg.MaxConcurrentChecks = 1
+	initCheckSlots()
+	defer func() {
+		cfg.MaxConcurrentChecks = 0
+		initCheckSlots()
+	}()
+
+	a := runCheck("a", 300*time.Millisecond)
+	b := runCheck("b", 300*time.Millisecond)
+	<-a.done
+	<-b.done
+
+	for id, run := range map[string]*checkRun{"a": a, "b": b} {
+		if !run.result.IsSuccess || run.result.Reason != "" {
+			t.Errorf("Check %s did not pass: %+v", id, run.result)
+		}
+	}
+}
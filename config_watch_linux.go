@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const watchDebounce = 250 * time.Millisecond
+
+// watchDefinitionsFile reloads the checks when a local definitions file
+// changes
+func watchDefinitionsFile() error {
+	w, err := newDefinitionsWatcher(cfg.CheckDefinitionsFiles)
+	if err != nil || w == nil {
+		return err
+	}
+	defer w.close()
+
+	changes := make(chan struct{}, 1)
+	go reloadOnChange(changes)
+
+	return w.run(changes)
+}
+
+// definitionsWatcher watches the directories containing the definitions
+// files instead of the files themselves as config management tools tend
+// to replace files through an atomic rename which would detach a watch
+// on the file
+type definitionsWatcher struct {
+	inotify *os.File
+	// Map of watch descriptors to the name patterns inside the directory
+	watches map[int32][]string
+}
+
+// newDefinitionsWatcher starts watching the local sources, it returns nil
+// if there is nothing to watch
+func newDefinitionsWatcher(sources []string) (*definitionsWatcher, error) {
+	// Map of watched directories to the name patterns inside them
+	patterns := map[string][]string{}
+	for _, source := range sources {
+		if source == "" || isRemoteSource(source) {
+			continue
+		}
+
+		source = filepath.Clean(strings.TrimPrefix(source, "file://"))
+		if info, err := os.Stat(source); err == nil && info.IsDir() {
+			patterns[source] = append(patterns[source], "*.yml", "*.yaml")
+			continue
+		}
+
+		dir, file := filepath.Split(source)
+		if dir == "" {
+			dir = "."
+		}
+		if strings.ContainsAny(dir, "*?[") {
+			logger.WithFields(logFields{"source": source}).Warnf("Unable to watch source: patterns are only supported for file names")
+			continue
+		}
+		patterns[filepath.Clean(dir)] = append(patterns[filepath.Clean(dir)], file)
+	}
+
+	if len(patterns) == 0 {
+		// No local files, nothing to watch
+		return nil, nil
+	}
+
+	// The descriptor is non-blocking to let the runtime poller handle it,
+	// which allows to interrupt a pending read by closing the file
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &definitionsWatcher{
+		inotify: os.NewFile(uintptr(fd), "inotify"),
+		watches: map[int32][]string{},
+	}
+
+	for dir, dirPatterns := range patterns {
+		wd, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_MOVED_FROM|syscall.IN_CREATE|syscall.IN_DELETE)
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+		w.watches[int32(wd)] = dirPatterns
+	}
+
+	return w, nil
+}
+
+// run signals changes of matching files until the watcher is closed
+func (w *definitionsWatcher) run(changes chan<- struct{}) error {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.inotify.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			if !matchesAny(w.watches[event.Wd], name) {
+				continue
+			}
+
+			select {
+			case changes <- struct{}{}:
+			default:
+				// Reload is already pending
+			}
+		}
+	}
+}
+
+func (w *definitionsWatcher) close() error {
+	return w.inotify.Close()
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadOnChange waits for the file to settle after a change before
+// reloading to coalesce the multiple events a single write causes
+func reloadOnChange(changes chan struct{}) {
+	for range changes {
+		time.Sleep(watchDebounce)
+
+		// Drop events which happened while waiting
+		select {
+		case <-changes:
+		default:
+		}
+
+		if err := reloadChecks("file change"); err != nil {
+			logger.WithFields(logFields{"error": err}).Errorf("Unable to reload checks")
+		}
+	}
+}
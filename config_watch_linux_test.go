@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefinitionsWatcher(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{})
+
+	dir := t.TempDir()
+	defs := filepath.Join(dir, "checks.yml")
+	cfg.CheckDefinitionsFiles = []string{defs}
+	cfg.TemplateDefinitions = false
+	cfg.DuplicateChecks = duplicateChecksOverride
+
+	// replace writes the definitions like config management tools do
+	replace := func(content string) {
+		tmp := filepath.Join(dir, ".checks.yml.tmp")
+		if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+			t.Fatalf("Writing definitions failed: %s", err)
+		}
+		if err := os.Rename(tmp, defs); err != nil {
+			t.Fatalf("Replacing definitions failed: %s", err)
+		}
+	}
+
+	replace("first:\n  name: first\n  command: \"true\"\n")
+	if err := reloadChecks("test"); err != nil {
+		t.Fatalf("Loading checks failed: %s", err)
+	}
+
+	w, err := newDefinitionsWatcher(cfg.CheckDefinitionsFiles)
+	if err != nil || w == nil {
+		t.Fatalf("Starting watcher failed: %v", err)
+	}
+
+	changes := make(chan struct{}, 1)
+	stopped := make(chan error)
+	go func() { stopped <- w.run(changes) }()
+
+	ioutil.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("foo"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "checks.yml.bak"), []byte("foo"), 0644)
+
+	select {
+	case <-changes:
+		t.Errorf("Writing unrelated files was reported as change")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	replace("first:\n  name: first\n  command: \"true\"\n")
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Replacing definitions through rename was not reported")
+	}
+
+	reloaded := make(chan struct{})
+	go func() {
+		reloadOnChange(changes)
+		close(reloaded)
+	}()
+
+	replace("second:\n  name: second\n  command: \"true\"\n")
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		if _, ok := getCheck("second"); ok {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Replaced definitions were not reloaded")
+		}
+	}
+	if _, ok := getCheck("first"); ok {
+		t.Errorf("Check removed from replaced definitions is still active")
+	}
+
+	w.close()
+	if err := <-stopped; err != nil {
+		t.Errorf("Watcher stopped with error: %s", err)
+	}
+	close(changes)
+	<-reloaded
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// watchDefinitionsFile is not supported on non-linux systems, the checks
+// are still refreshed through the config refresh interval and SIGHUP
+func watchDefinitionsFile() error { return nil }
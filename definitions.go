@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	duplicateChecksOverride = "override"
+	duplicateChecksError    = "error"
+)
+
+func validateDuplicateChecks() error {
+	switch cfg.DuplicateChecks {
+	case duplicateChecksOverride, duplicateChecksError:
+		return nil
+	default:
+		return fmt.Errorf("Unknown handling of duplicate checks %q", cfg.DuplicateChecks)
+	}
+}
+
+// loadChecks reads the checks from all configured sources and merges
+// them in the order the sources were given. If a source can not be read
+// the whole set of checks is rejected to prevent checks from vanishing
+// because of a temporary error.
+func loadChecks() (map[string]checkCommand, error) {
+	sources, err := expandDefinitionSources(cfg.CheckDefinitionsFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]checkCommand{}
+	for _, source := range sources {
+		sourceChecks, err := loadChecksFromSource(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", source, err)
+		}
+
+		// Iterate in a stable order to have deterministic log output
+		ids := []string{}
+		for id := range sourceChecks {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			check := sourceChecks[id]
+			check.Source = source
+
+			if existing, ok := result[id]; ok {
+				if cfg.DuplicateChecks == duplicateChecksError {
+					return nil, fmt.Errorf("Check %q is defined in %s and %s", id, existing.Source, source)
+				}
+				logger.WithFields(logFields{"check_id": id, "source": source, "overridden_source": existing.Source}).Infof("Check definition overrides previous definition")
+			}
+
+			result[id] = check
+		}
+
+		logger.WithFields(logFields{"source": source, "checks": len(sourceChecks)}).Debugf("Loaded check definitions")
+	}
+
+	return result, nil
+}
+
+// expandDefinitionSources resolves directories and glob patterns into the
+// files they contain. Directories are expanded to the YAML files inside
+// them, all expansions are sorted by name.
+func expandDefinitionSources(sources []string) ([]string, error) {
+	expanded := []string{}
+
+	for _, source := range sources {
+		if source == "" {
+			continue
+		}
+
+		if isRemoteSource(source) {
+			expanded = append(expanded, source)
+			continue
+		}
+
+		source = strings.TrimPrefix(source, "file://")
+		switch {
+		case strings.ContainsAny(source, "*?["):
+			matches, err := filepath.Glob(source)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid pattern %q: %s", source, err)
+			}
+			sort.Strings(matches)
+			expanded = append(expanded, matches...)
+
+		default:
+			if info, err := os.Stat(source); err == nil && info.IsDir() {
+				matches, err := yamlFilesInDir(source)
+				if err != nil {
+					return nil, err
+				}
+				expanded = append(expanded, matches...)
+				continue
+			}
+			expanded = append(expanded, source)
+		}
+	}
+
+	return expanded, nil
+}
+
+func yamlFilesInDir(dir string) ([]string, error) {
+	matches := []string{}
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		m, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m...)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func loadChecksFromSource(source string) (map[string]checkCommand, error) {
+	if !isRemoteSource(source) {
+		rawChecks, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		return parseChecks(rawChecks)
+	}
+
+	rawChecks, err := fetchFromSource(source)
+	if err == nil {
+		err = verifyDefinitions(source, rawChecks)
+	}
+	if err != nil {
+		if cfg.DefinitionsCacheDir == "" {
+			return nil, err
+		}
+
+		logger.WithFields(logFields{"source": source, "error": err}).Warnf("Unable to load remote definitions, using cached definitions")
+		if rawChecks, err = readDefinitionsCache(source); err != nil {
+			return nil, fmt.Errorf("Unable to read cached definitions: %s", err)
+		}
+		return parseChecks(rawChecks)
+	}
+
+	checks, err := parseChecks(rawChecks)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DefinitionsCacheDir != "" {
+		if err := writeDefinitionsCache(source, rawChecks); err != nil {
+			logger.WithFields(logFields{"source": source, "error": err}).Warnf("Unable to write definitions cache")
+		}
+	}
+
+	return checks, nil
+}
+
+func parseChecks(rawChecks []byte) (map[string]checkCommand, error) {
+	if cfg.TemplateDefinitions {
+		var err error
+		if rawChecks, err = renderDefinitions(rawChecks); err != nil {
+			return nil, fmt.Errorf("Unable to render definitions: %s", err)
+		}
+	}
+
+	result := map[string]checkCommand{}
+	if err := yaml.Unmarshal(rawChecks, &result); err != nil {
+		return nil, err
+	}
+
+	for id, check := range result {
+		if check.Name == "" {
+			return nil, fmt.Errorf("Check %q has no name", id)
+		}
+		if check.Command.isEmpty() {
+			return nil, fmt.Errorf("Check %q has no command", id)
+		}
+		if check.Command.Args != nil && check.Shell != "" {
+			return nil, fmt.Errorf("Check %q has a shell but its command is given as list", id)
+		}
+		if err := validateSplay(check.Splay); err != nil {
+			return nil, fmt.Errorf("Check %q has invalid splay: %s", id, err)
+		}
+		if err := check.Remediate.validate(); err != nil {
+			return nil, fmt.Errorf("Check %q has invalid remediation: %s", id, err)
+		}
+		if _, err := parseCheckLimits(check); err != nil {
+			return nil, fmt.Errorf("Check %q has invalid limits: %s", id, err)
+		}
+	}
+
+	return result, nil
+}
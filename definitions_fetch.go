@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	maxDefinitionsSize = 10 * 1024 * 1024
+
+	fetchBackoffBase = time.Second
+	fetchBackoffMax  = 30 * time.Second
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// remoteDefinitionsFetcher retrieves definitions from an URL and keeps
+// the last response to issue conditional requests and not to transfer
+// unchanged definitions over and over again
+type remoteDefinitionsFetcher struct {
+	lock sync.Mutex
+
+	url string
+	// sign is called to authenticate every request if set
+	sign func(*http.Request) error
+
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// fetchError is returned for unsuccessful responses, Resource describes
+// what was requested for example the URL
+type fetchError struct {
+	Resource   string
+	StatusCode int
+}
+
+func (f fetchError) Error() string {
+	return fmt.Sprintf("Unexpected HTTP status %d fetching %s", f.StatusCode, f.Resource)
+}
+
+// retryable reports whether retrying the request might yield a different
+// result than the error passed
+func retryable(err error) bool {
+	if fe, ok := err.(fetchError); ok {
+		return fe.StatusCode >= 500 || fe.StatusCode == http.StatusTooManyRequests || fe.StatusCode == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// backoff calculates the delay before the given retry attempt using full
+// jitter to avoid a whole fleet of instances retrying in lock-step
+func backoff(attempt int) time.Duration {
+	d := fetchBackoffBase << uint(attempt)
+	if d > fetchBackoffMax || d <= 0 {
+		d = fetchBackoffMax
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func (r *remoteDefinitionsFetcher) fetch() ([]byte, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var err error
+	for attempt := 0; attempt <= cfg.DefinitionsFetchRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt - 1))
+		}
+
+		var body []byte
+		if body, err = r.fetchOnce(); err == nil {
+			return body, nil
+		}
+
+		if !retryable(err) {
+			break
+		}
+	}
+
+	return nil, err
+}
+
+func (r *remoteDefinitionsFetcher) fetchOnce() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.sign != nil {
+		if err := r.sign(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.body != nil {
+		if r.etag != "" {
+			req.Header.Set("If-None-Match", r.etag)
+		}
+		if r.lastModified != "" {
+			req.Header.Set("If-Modified-Since", r.lastModified)
+		}
+	}
+
+	client := &http.Client{Timeout: cfg.DefinitionsFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && r.body != nil:
+		return r.body, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fetchError{Resource: r.url, StatusCode: resp.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDefinitionsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDefinitionsSize {
+		return nil, fmt.Errorf("Definitions exceed maximum size of %d bytes", maxDefinitionsSize)
+	}
+
+	r.etag = resp.Header.Get("ETag")
+	r.lastModified = resp.Header.Get("Last-Modified")
+	r.body = body
+
+	return body, nil
+}
+
+// definitionsCacheFile returns the location of the cached definitions
+// for the given URL
+func definitionsCacheFile(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cfg.DefinitionsCacheDir, hex.EncodeToString(sum[:8])+".yml")
+}
+
+func readDefinitionsCache(url string) ([]byte, error) {
+	return ioutil.ReadFile(definitionsCacheFile(url))
+}
+
+// writeDefinitionsCache stores the definitions atomically so a crash
+// while writing does not leave a broken cache behind
+func writeDefinitionsCache(url string, rawChecks []byte) error {
+	tmp, err := ioutil.TempFile(cfg.DefinitionsCacheDir, ".definitions-cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(rawChecks); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), definitionsCacheFile(url))
+}
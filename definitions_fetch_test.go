@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteDefinitionsFetcher(t *testing.T) {
+	var requests int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/missing.yml":
+			http.NotFound(res, r)
+		case "/checks.yml":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				res.WriteHeader(http.StatusNotModified)
+				return
+			}
+			res.Header().Set("ETag", `"v1"`)
+			res.Write([]byte("foo: {}"))
+		}
+	}))
+	defer srv.Close()
+
+	cfg.DefinitionsFetchTimeout = time.Second
+	cfg.DefinitionsFetchRetries = 2
+
+	for i := 0; i < 2; i++ {
+		body, err := fetchFromSource(srv.URL + "/checks.yml")
+		if err != nil {
+			t.Fatalf("Fetch #%d returned unexpected error: %s", i, err)
+		}
+		if string(body) != "foo: {}" {
+			t.Fatalf("Fetch #%d returned unexpected body: %q", i, body)
+		}
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	if _, err := fetchFromSource(srv.URL + "/missing.yml"); err == nil {
+		t.Fatalf("Fetching a missing file did not return an error")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("Fetching a missing file was retried: %d requests", n)
+	}
+}
+
+func TestRemoteDefinitionsRetries(t *testing.T) {
+	var requests int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/broken.yml" || n == 1 {
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		res.Write([]byte("foo: {}"))
+	}))
+	defer srv.Close()
+
+	cfg.DefinitionsFetchTimeout = time.Second
+	cfg.DefinitionsFetchRetries = 1
+
+	body, err := fetchFromSource(srv.URL + "/flaky.yml")
+	if err != nil || string(body) != "foo: {}" {
+		t.Fatalf("Failed fetch was not retried: %q (%v)", body, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected 2 requests, got %d", n)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	_, err = fetchFromSource(srv.URL + "/broken.yml")
+	if fe, ok := err.(fetchError); !ok || fe.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status error after exhausting retries, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected retries to be limited to 1, got %d requests", n)
+	}
+}
+
+func TestRemoteDefinitionsIfModifiedSince(t *testing.T) {
+	var requests, notModified int32
+	lastModified := time.Now().UTC().Format(http.TimeFormat)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			atomic.AddInt32(&notModified, 1)
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
+		res.Header().Set("Last-Modified", lastModified)
+		res.Write([]byte("foo: {}"))
+	}))
+	defer srv.Close()
+
+	cfg.DefinitionsFetchTimeout = time.Second
+	cfg.DefinitionsFetchRetries = 0
+
+	for i := 0; i < 2; i++ {
+		body, err := fetchFromSource(srv.URL + "/modified.yml")
+		if err != nil || string(body) != "foo: {}" {
+			t.Fatalf("Fetch #%d returned unexpected result: %q (%v)", i, body, err)
+		}
+	}
+
+	if atomic.LoadInt32(&requests) != 2 || atomic.LoadInt32(&notModified) != 1 {
+		t.Errorf("Expected second request to be conditional, got %d requests, %d not modified", requests, notModified)
+	}
+}
+
+func TestLoadChecksFromCache(t *testing.T) {
+	defs := "docker:\n  name: Docker is running\n  command: \"true\"\n"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		res.Write([]byte(defs))
+	}))
+
+	cfg.DefinitionsCacheDir = t.TempDir()
+	cfg.DefinitionsFetchTimeout = time.Second
+	cfg.DefinitionsFetchRetries = 0
+	cfg.DefinitionsVerify = verifyNone
+	cfg.TemplateDefinitions = false
+	defer func() { cfg.DefinitionsCacheDir = "" }()
+
+	source := srv.URL + "/cached.yml"
+	if _, err := loadChecksFromSource(srv.URL + "/uncached.yml"); err != nil {
+		t.Fatalf("Loading definitions failed: %s", err)
+	}
+	if _, err := loadChecksFromSource(source); err != nil {
+		t.Fatalf("Loading definitions failed: %s", err)
+	}
+	if raw, err := readDefinitionsCache(source); err != nil || string(raw) != defs {
+		t.Fatalf("Definitions were not cached: %q (%v)", raw, err)
+	}
+
+	// Simulate booting while the URL is unreachable
+	srv.Close()
+	os.Remove(definitionsCacheFile(srv.URL + "/uncached.yml"))
+
+	checks, err := loadChecksFromSource(source)
+	if err != nil {
+		t.Fatalf("Cached definitions were not used: %s", err)
+	}
+	if checks["docker"].Name != "Docker is running" {
+		t.Errorf("Unexpected checks loaded from cache: %+v", checks)
+	}
+
+	if _, err := loadChecksFromSource(srv.URL + "/uncached.yml"); err == nil {
+		t.Errorf("Expected unreachable URL without cache to fail")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// definitionSource retrieves raw definitions from a remote location
+type definitionSource interface {
+	fetch() ([]byte, error)
+}
+
+// sourceBackends contains the constructors for the supported remote
+// sources keyed by their URL scheme. Local files (with or without a
+// file:// scheme) are handled separately as they support directories
+// and glob patterns.
+var sourceBackends = map[string]func(u *url.URL) (definitionSource, error){
+	"http":  newHTTPSource,
+	"https": newHTTPSource,
+	"s3":    newS3Source,
+}
+
+var (
+	definitionSources     = map[string]definitionSource{}
+	definitionSourcesLock sync.Mutex
+)
+
+func isRemoteSource(source string) bool {
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	_, ok := sourceBackends[u.Scheme]
+	return ok
+}
+
+// sourceFor returns the source for the given location. Sources are kept
+// to allow them to keep state between fetches.
+func sourceFor(location string) (definitionSource, error) {
+	definitionSourcesLock.Lock()
+	defer definitionSourcesLock.Unlock()
+
+	if src, ok := definitionSources[location]; ok {
+		return src, nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, ok := sourceBackends[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported source scheme %q", u.Scheme)
+	}
+
+	src, err := backend(u)
+	if err != nil {
+		return nil, err
+	}
+
+	definitionSources[location] = src
+	return src, nil
+}
+
+func fetchFromSource(location string) ([]byte, error) {
+	src, err := sourceFor(location)
+	if err != nil {
+		return nil, err
+	}
+	return src.fetch()
+}
+
+func newHTTPSource(u *url.URL) (definitionSource, error) {
+	return &remoteDefinitionsFetcher{url: u.String()}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+type definitionsTemplateData struct {
+	Env      map[string]string
+	Hostname string
+}
+
+var definitionsTemplateFuncs = template.FuncMap{
+	"default":  templateDefault,
+	"metadata": templateMetadata,
+	"tag":      templateTag,
+}
+
+// renderDefinitions executes the definitions as a template to allow them
+// to differ depending on the environment of the instance
+func renderDefinitions(rawChecks []byte) ([]byte, error) {
+	return renderTemplate("definitions", string(rawChecks))
+}
+
+// renderTemplateString renders a single configuration value using the
+// same data and functions as the definitions
+func renderTemplateString(value string) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+
+	out, err := renderTemplate("value", value)
+	return string(out), err
+}
+
+func renderTemplate(name, text string) ([]byte, error) {
+	tpl, err := template.New(name).
+		Option("missingkey=zero").
+		Funcs(definitionsTemplateFuncs).
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	data := definitionsTemplateData{
+		Env:      map[string]string{},
+		Hostname: hostname,
+	}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		data.Env[parts[0]] = parts[1]
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tpl.Execute(buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// templateDefault returns the value or the default if the value is empty:
+// {{ .Env.INODE_LIMIT | default "70" }}
+func templateDefault(def, value string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// templateMetadata returns the given path from the instance metadata:
+// {{ metadata "placement/availability-zone" }}
+func templateMetadata(path string) (string, error) {
+	return metadataClient.get("meta-data/" + strings.TrimLeft(path, "/"))
+}
+
+// templateTag returns the value of the given instance tag or an empty
+// string if the tag is not set. Requires access to tags in the instance
+// metadata to be enabled: {{ if eq (tag "Role") "web" }}...{{ end }}
+func templateTag(name string) (string, error) {
+	value, err := metadataClient.get("meta-data/tags/instance/" + name)
+	if fe, ok := err.(fetchError); ok && fe.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	return value, err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withTestMetadata serves the given metadata paths and returns a function
+// restoring the previous metadata client
+func withTestMetadata(values map[string]string) func() {
+	imds := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+			res.Write([]byte("token"))
+			return
+		}
+
+		v, ok := values[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/")]
+		if !ok {
+			http.NotFound(res, r)
+			return
+		}
+		res.Write([]byte(v))
+	}))
+
+	oldClient := metadataClient
+	metadataClient = &imdsClient{}
+	cfg.IMDSEndpoint = imds.URL
+
+	return func() {
+		imds.Close()
+		metadataClient = oldClient
+	}
+}
+
+func TestRenderDefinitions(t *testing.T) {
+	defer withTestMetadata(map[string]string{
+		"placement/availability-zone": "eu-west-1a",
+		"tags/instance/Role":          "web",
+	})()
+
+	os.Setenv("TEST_INODE_LIMIT", "80")
+	defer os.Unsetenv("TEST_INODE_LIMIT")
+
+	hostname, _ := os.Hostname()
+
+	for tpl, expect := range map[string]string{
+		`{{ .Env.TEST_INODE_LIMIT }}`:                  "80",
+		`{{ .Env.TEST_UNSET | default "70" }}`:         "70",
+		`{{ .Env.TEST_UNSET }}`:                        "",
+		`{{ .Hostname }}`:                              hostname,
+		`{{ metadata "placement/availability-zone" }}`: "eu-west-1a",
+		`{{ if eq (tag "Role") "web" }}nginx{{ end }}`: "nginx",
+		`{{ tag "Team" }}`:                             "",
+		`check: {name: "{{ .Env.TEST_INODE_LIMIT }}"}`: `check: {name: "80"}`,
+	} {
+		out, err := renderDefinitions([]byte(tpl))
+		if err != nil {
+			t.Errorf("Rendering %s failed: %s", tpl, err)
+			continue
+		}
+		if string(out) != expect {
+			t.Errorf("Unexpected output of %s: %q", tpl, out)
+		}
+	}
+}
+
+func TestRenderDefinitionsErrors(t *testing.T) {
+	defer withTestMetadata(map[string]string{})()
+
+	for tpl, expect := range map[string]string{
+		`{{ .Missing }}`:                    "can't evaluate field Missing",
+		`{{ .Env.FOO`:                       "unclosed action",
+		`{{ unknown "foo" }}`:               `function "unknown" not defined`,
+		`{{ metadata "placement/region" }}`: `Unexpected HTTP status 404 fetching metadata "meta-data/placement/region"`,
+	} {
+		_, err := renderDefinitions([]byte(tpl))
+		if err == nil {
+			t.Errorf("Expected rendering %s to fail", tpl)
+			continue
+		}
+		if !strings.Contains(err.Error(), expect) {
+			t.Errorf("Unexpected error rendering %s: %s", tpl, err)
+		}
+	}
+}
+
+func TestRenderTemplateString(t *testing.T) {
+	if out, err := renderTemplateString("plain {value}"); err != nil || out != "plain {value}" {
+		t.Errorf("Value without template was modified: %q (%v)", out, err)
+	}
+
+	hostname, _ := os.Hostname()
+	if out, err := renderTemplateString("{{ .Hostname }}"); err != nil || out != hostname {
+		t.Errorf("Unexpected rendered value: %q (%v)", out, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateDuplicateChecks(t *testing.T) {
+	defer func() { cfg.DuplicateChecks = duplicateChecksOverride }()
+
+	for value, valid := range map[string]bool{
+		"override": true,
+		"error":    true,
+		"overide":  false,
+		"":         false,
+	} {
+		cfg.DuplicateChecks = value
+		if err := validateDuplicateChecks(); (err == nil) != valid {
+			t.Errorf("Unexpected validation result for %q: %v", value, err)
+		}
+	}
+}
+
+func TestLoadChecksDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.yml": "docker:\n  name: first\n  command: \"true\"\n",
+		"b.yml": "docker:\n  name: second\n  command: \"true\"\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Writing definitions failed: %s", err)
+		}
+	}
+
+	cfg.CheckDefinitionsFiles = []string{filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yml")}
+	cfg.TemplateDefinitions = false
+	defer func() { cfg.DuplicateChecks = duplicateChecksOverride }()
+
+	cfg.DuplicateChecks = duplicateChecksOverride
+	checks, err := loadChecks()
+	if err != nil {
+		t.Fatalf("Loading checks failed: %s", err)
+	}
+	if checks["docker"].Name != "second" || checks["docker"].Source != cfg.CheckDefinitionsFiles[1] {
+		t.Errorf("Later source did not override check: %+v", checks["docker"])
+	}
+
+	cfg.DuplicateChecks = duplicateChecksError
+	if _, err := loadChecks(); err == nil {
+		t.Errorf("Duplicate check was accepted")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	verifyNone    = "none"
+	verifySHA256  = "sha256"
+	verifyEd25519 = "ed25519"
+)
+
+func validateVerifyConfig() error {
+	switch cfg.DefinitionsVerify {
+	case verifyNone:
+		return nil
+	case verifySHA256:
+		sums, err := loadChecksums()
+		if err != nil {
+			return err
+		}
+		if len(sums) == 0 {
+			return errors.New("SHA-256 verification requires at least one checksum")
+		}
+		return nil
+	case verifyEd25519:
+		keys, err := loadPublicKeys()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return errors.New("Ed25519 verification requires at least one public key")
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown verification method %q", cfg.DefinitionsVerify)
+	}
+}
+
+// verifyDefinitions checks the definitions fetched from the given URL
+// against a locally configured checksum or a detached signature (<url>.sig)
+// fetched alongside the definitions
+func verifyDefinitions(source string, rawChecks []byte) error {
+	switch cfg.DefinitionsVerify {
+	case verifySHA256:
+		// The checksum must not be fetched from the same place as the
+		// definitions as whoever can modify one can modify the other
+		sums, err := loadChecksums()
+		if err != nil {
+			return err
+		}
+		sum, ok := sums[source]
+		if !ok {
+			return fmt.Errorf("No checksum configured for %s", source)
+		}
+		return verifyChecksum(rawChecks, []byte(sum))
+
+	case verifyEd25519:
+		sigURL, err := sidecarURL(source, ".sig")
+		if err != nil {
+			return err
+		}
+		rawSig, err := fetchFromSource(sigURL)
+		if err != nil {
+			return fmt.Errorf("Unable to fetch definitions signature: %s", err)
+		}
+		keys, err := loadPublicKeys()
+		if err != nil {
+			return err
+		}
+		return verifySignature(rawChecks, rawSig, keys)
+	}
+
+	return nil
+}
+
+// sidecarURL appends the suffix to the path of the URL, keeping query
+// parameters like the ones of presigned URLs intact
+func sidecarURL(source, suffix string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path += suffix
+	if u.RawPath != "" {
+		u.RawPath += suffix
+	}
+	return u.String(), nil
+}
+
+// verifyChecksum accepts the checksum in the format written by sha256sum
+func verifyChecksum(rawChecks, rawSum []byte) error {
+	fields := strings.Fields(string(rawSum))
+	if len(fields) == 0 {
+		return errors.New("Definitions checksum is empty")
+	}
+
+	expected, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return fmt.Errorf("Definitions checksum is invalid: %s", err)
+	}
+
+	actual := sha256.Sum256(rawChecks)
+	if !bytes.Equal(expected, actual[:]) {
+		return errors.New("Definitions do not match their checksum")
+	}
+
+	return nil
+}
+
+// verifySignature accepts raw or base64 encoded signatures
+func verifySignature(rawChecks, rawSig []byte, keys []ed25519.PublicKey) error {
+	sig := rawSig
+	if len(sig) != ed25519.SignatureSize {
+		var err error
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(rawSig))); err != nil {
+			return fmt.Errorf("Definitions signature is invalid: %s", err)
+		}
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, rawChecks, sig) {
+			return nil
+		}
+	}
+
+	return errors.New("Definitions signature does not match any trusted public key")
+}
+
+// loadChecksums reads the configured checksum file in the format written by
+// sha256sum having the URL of the definitions as file name
+func loadChecksums() (map[string]string, error) {
+	sums := map[string]string{}
+	if cfg.DefinitionsChecksums == "" {
+		return sums, nil
+	}
+
+	f, err := os.Open(cfg.DefinitionsChecksums)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid checksum line in %s", cfg.DefinitionsChecksums)
+		}
+		if sum, err := hex.DecodeString(fields[0]); err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("Invalid checksum for %s in %s", fields[1], cfg.DefinitionsChecksums)
+		}
+
+		// sha256sum marks files read in binary mode using an asterisk
+		sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+
+	return sums, scanner.Err()
+}
+
+// loadPublicKeys reads the configured key files which contain one base64
+// encoded key per line, empty lines and lines starting with # are ignored
+func loadPublicKeys() ([]ed25519.PublicKey, error) {
+	keys := []ed25519.PublicKey{}
+
+	for _, keyFile := range cfg.DefinitionsPublicKeys {
+		if keyFile == "" {
+			continue
+		}
+
+		f, err := os.Open(keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			key, err := base64.StdEncoding.DecodeString(line)
+			if err != nil || len(key) != ed25519.PublicKeySize {
+				f.Close()
+				return nil, fmt.Errorf("Invalid public key in %s", keyFile)
+			}
+			keys = append(keys, ed25519.PublicKey(key))
+		}
+		err = scanner.Err()
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	defs := []byte("foo: {}")
+	sum := sha256.Sum256(defs)
+
+	if err := verifyChecksum(defs, []byte(hex.EncodeToString(sum[:])+"  checks.yml\n")); err != nil {
+		t.Fatalf("Valid checksum was rejected: %s", err)
+	}
+
+	if err := verifyChecksum([]byte("bar: {}"), []byte(hex.EncodeToString(sum[:]))); err == nil {
+		t.Fatalf("Tampered definitions were accepted")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+
+	defs := []byte("foo: {}")
+	sig := ed25519.Sign(priv, defs)
+
+	if err := verifySignature(defs, sig, []ed25519.PublicKey{otherPub, pub}); err != nil {
+		t.Fatalf("Valid raw signature was rejected: %s", err)
+	}
+
+	if err := verifySignature(defs, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), []ed25519.PublicKey{pub}); err != nil {
+		t.Fatalf("Valid base64 signature was rejected: %s", err)
+	}
+
+	if err := verifySignature([]byte("bar: {}"), sig, []ed25519.PublicKey{pub}); err == nil {
+		t.Fatalf("Tampered definitions were accepted")
+	}
+
+	if err := verifySignature(defs, sig, []ed25519.PublicKey{otherPub}); err == nil {
+		t.Fatalf("Signature of untrusted key was accepted")
+	}
+}
+
+func TestSidecarURL(t *testing.T) {
+	for in, expect := range map[string]string{
+		"https://example.com/checks.yml":                     "https://example.com/checks.yml.sig",
+		"https://example.com/checks.yml?X-Amz-Signature=abc": "https://example.com/checks.yml.sig?X-Amz-Signature=abc",
+		"https://example.com/my%2Fchecks.yml?v=1":            "https://example.com/my%2Fchecks.yml.sig?v=1",
+		"s3://bucket/checks.yml":                             "s3://bucket/checks.yml.sig",
+	} {
+		out, err := sidecarURL(in, ".sig")
+		if err != nil {
+			t.Fatalf("Building URL for %s failed: %s", in, err)
+		}
+		if out != expect {
+			t.Errorf("Expected %s for %s, got %s", expect, in, out)
+		}
+	}
+}
+
+func TestVerifyDefinitionsWithQuery(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	defs := []byte("foo: {}")
+	sig := ed25519.Sign(priv, defs)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/checks.yml.sig" || r.URL.Query().Get("token") != "abc" {
+			http.NotFound(res, r)
+			return
+		}
+		res.Write(sig)
+	}))
+	defer srv.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0600)
+
+	cfg.DefinitionsVerify = verifyEd25519
+	cfg.DefinitionsPublicKeys = []string{keyFile}
+	cfg.DefinitionsFetchTimeout = time.Second
+	defer func() { cfg.DefinitionsVerify, cfg.DefinitionsPublicKeys = verifyNone, nil }()
+
+	if err := verifyDefinitions(srv.URL+"/checks.yml?token=abc", defs); err != nil {
+		t.Fatalf("Verifying definitions fetched with query failed: %s", err)
+	}
+}
+
+func TestVerifyPinnedChecksum(t *testing.T) {
+	defs := []byte("foo: {}")
+	sum := sha256.Sum256(defs)
+	source := "https://example.com/checks.yml"
+
+	sumFile := filepath.Join(t.TempDir(), "checksums")
+	ioutil.WriteFile(sumFile, []byte("# pinned definitions\n"+hex.EncodeToString(sum[:])+" *"+source+"\n"), 0600)
+
+	cfg.DefinitionsVerify = verifySHA256
+	cfg.DefinitionsChecksums = ""
+	defer func() { cfg.DefinitionsVerify, cfg.DefinitionsChecksums = verifyNone, "" }()
+
+	if err := validateVerifyConfig(); err == nil {
+		t.Errorf("SHA-256 verification without checksums was accepted")
+	}
+
+	cfg.DefinitionsChecksums = sumFile
+	if err := validateVerifyConfig(); err != nil {
+		t.Fatalf("Valid checksum file was rejected: %s", err)
+	}
+
+	// Nothing is fetched, so the URL does not need to be reachable
+	if err := verifyDefinitions(source, defs); err != nil {
+		t.Errorf("Definitions matching the pinned checksum were rejected: %s", err)
+	}
+	if err := verifyDefinitions(source, []byte("bar: {}")); err == nil {
+		t.Errorf("Tampered definitions were accepted")
+	}
+	if err := verifyDefinitions("https://example.com/other.yml", defs); err == nil {
+		t.Errorf("Definitions without pinned checksum were accepted")
+	}
+
+	ioutil.WriteFile(sumFile, []byte("abc "+source+"\n"), 0600)
+	if err := validateVerifyConfig(); err == nil {
+		t.Errorf("Invalid checksum file was accepted")
+	}
+}
@@ -8,6 +8,8 @@ require (
 	github.com/Luzifer/rconfig v2.2.0+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/prometheus/client_golang v1.12.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.32.1
 	github.com/robfig/cron v1.2.0
 	golang.org/x/net v0.7.0
 	gopkg.in/yaml.v2 v2.4.0
@@ -20,8 +22,6 @@ require (
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.18.1 // indirect
-	github.com/prometheus/client_model v0.2.0 // indirect
-	github.com/prometheus/common v0.32.1 // indirect
 	github.com/prometheus/procfs v0.7.3 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sys v0.5.0 // indirect
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	autoscalingAPIVersion = "2011-01-01"
+	ec2APIVersion         = "2016-11-15"
+
+	awsAPITimeout = 10 * time.Second
+)
+
+// healthReporter informs an external system about the overall health of
+// the instance, for example for instances not behind a load balancer
+type healthReporter interface {
+	reportHealth(healthy bool) error
+}
+
+var (
+	healthReporters []healthReporter
+
+	// pendingHealth holds the latest verdict not yet picked up by the
+	// report worker, healthReportSignal wakes the worker up
+	pendingHealth      *bool
+	pendingHealthLock  sync.Mutex
+	healthReportSignal = make(chan struct{}, 1)
+)
+
+func initHealthReporters() {
+	if cfg.ReportASGHealth {
+		healthReporters = append(healthReporters, &asgHealthReporter{})
+	}
+
+	if len(healthReporters) > 0 {
+		go runHealthReports()
+	}
+}
+
+// reportHealth hands the verdict to the report worker. A verdict not yet
+// picked up by the worker is replaced as it is outdated.
+func reportHealth(healthy bool) {
+	if len(healthReporters) == 0 {
+		return
+	}
+
+	pendingHealthLock.Lock()
+	pendingHealth = &healthy
+	pendingHealthLock.Unlock()
+
+	select {
+	case healthReportSignal <- struct{}{}:
+	default:
+		// Worker is already signalled and will pick up the new verdict
+	}
+}
+
+// runHealthReports is the only worker sending reports so they are
+// serialized and an outdated verdict is never reported after a newer one
+func runHealthReports() {
+	for range healthReportSignal {
+		for {
+			pendingHealthLock.Lock()
+			healthy := pendingHealth
+			pendingHealth = nil
+			pendingHealthLock.Unlock()
+
+			if healthy == nil {
+				break
+			}
+
+			deliverHealth(*healthy)
+		}
+	}
+}
+
+func hasPendingHealth() bool {
+	pendingHealthLock.Lock()
+	defer pendingHealthLock.Unlock()
+	return pendingHealth != nil
+}
+
+// deliverHealth reports the verdict to all reporters and retries the
+// failed ones until they succeed or a newer verdict is available
+func deliverHealth(healthy bool) {
+	remaining := healthReporters
+
+	for attempt := 0; ; attempt++ {
+		var failed []healthReporter
+		for _, r := range remaining {
+			if err := r.reportHealth(healthy); err != nil {
+				logger.WithFields(logFields{"healthy": healthy, "attempt": attempt + 1, "error": err}).Errorf("Unable to report health")
+				failed = append(failed, r)
+			}
+		}
+
+		if len(failed) == 0 {
+			return
+		}
+		remaining = failed
+
+		select {
+		case <-time.After(backoff(attempt)):
+		case <-healthReportSignal:
+			// A newer verdict replaces the one failed to report
+			if hasPendingHealth() {
+				return
+			}
+		}
+	}
+}
+
+// asgHealthReporter marks the instance unhealthy in its Auto Scaling group
+// and optionally tags the EC2 instance with its health
+type asgHealthReporter struct {
+	instanceID string
+	region     string
+}
+
+// resolveInstance determines the instance ID and region once
+func (a *asgHealthReporter) resolveInstance() error {
+	if a.instanceID != "" {
+		return nil
+	}
+
+	region, err := awsRegion()
+	if err != nil {
+		return err
+	}
+
+	instanceID, err := metadataClient.get("meta-data/instance-id")
+	if err != nil {
+		return fmt.Errorf("Unable to determine instance ID: %s", err)
+	}
+
+	a.region, a.instanceID = region, instanceID
+	return nil
+}
+
+func (a *asgHealthReporter) reportHealth(healthy bool) error {
+	if err := a.resolveInstance(); err != nil {
+		return err
+	}
+
+	// Auto Scaling does not allow to mark an instance healthy again after
+	// it was marked unhealthy, so only the unhealthy verdict is reported
+	if !healthy {
+		if err := a.call("autoscaling", cfg.AutoscalingEndpoint, url.Values{
+			"Action":       {"SetInstanceHealth"},
+			"Version":      {autoscalingAPIVersion},
+			"InstanceId":   {a.instanceID},
+			"HealthStatus": {"Unhealthy"},
+		}); err != nil {
+			return err
+		}
+		logger.WithFields(logFields{"instance_id": a.instanceID}).Infof("Marked instance unhealthy in its Auto Scaling group")
+	}
+
+	if cfg.HealthTag != "" {
+		status := "healthy"
+		if !healthy {
+			status = "unhealthy"
+		}
+
+		if err := a.call("ec2", cfg.EC2Endpoint, url.Values{
+			"Action":       {"CreateTags"},
+			"Version":      {ec2APIVersion},
+			"ResourceId.1": {a.instanceID},
+			"Tag.1.Key":    {cfg.HealthTag},
+			"Tag.1.Value":  {status},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// call executes a request against an AWS query API
+func (a *asgHealthReporter) call(service, endpoint string, params url.Values) error {
+	if endpoint == "" {
+		endpoint = "https://" + service + "." + a.region + ".amazonaws.com/"
+	}
+
+	body := []byte(params.Encode())
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds, err := awsCredentials()
+	if err != nil {
+		return err
+	}
+	signV4(req, body, creds, a.region, service, time.Now())
+
+	client := &http.Client{Timeout: awsAPITimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned HTTP status %d: %s", params.Get("Action"), resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const historyFileVersion = 1
+
+// historyRun is a single execution of a check
+type historyRun struct {
+	Time     time.Time `json:"time"`
+	State    string    `json:"state"`
+	Success  bool      `json:"success"`
+	Duration float64   `json:"duration_seconds"`
+	Reason   string    `json:"reason,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// historyTransition is a change of the state of a check
+type historyTransition struct {
+	Time     time.Time `json:"time"`
+	OldState string    `json:"old_state"`
+	NewState string    `json:"new_state"`
+	Streak   int64     `json:"streak"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// checkHistory contains the last runs and transitions of a check, both
+// bounded by --history-size
+type checkHistory struct {
+	Runs        []historyRun        `json:"runs"`
+	Transitions []historyTransition `json:"transitions"`
+}
+
+type historyFile struct {
+	Version int                      `json:"version"`
+	SavedAt time.Time                `json:"saved_at"`
+	Checks  map[string]*checkHistory `json:"checks"`
+}
+
+type apiCheckHistory struct {
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	State       string              `json:"state"`
+	Since       time.Time           `json:"since"`
+	Flaps       int                 `json:"flaps"`
+	TimeInState map[string]float64  `json:"time_in_state_seconds"`
+	Transitions []historyTransition `json:"transitions"`
+	Runs        []historyRun        `json:"runs"`
+}
+
+var (
+	histories     = map[string]*checkHistory{}
+	historiesLock sync.RWMutex
+
+	historySnapshot = newSnapshotWriter("history", &cfg.HistoryFile, historyFileVersion, marshalHistory)
+)
+
+// recordHistory adds the result of a check execution to its history and
+// records a transition if the state of the check changed from oldState,
+// which is empty if the previous state is unknown
+func recordHistory(checkID, oldState string, result checkResult, duration time.Duration) {
+	if cfg.HistorySize <= 0 {
+		return
+	}
+
+	newState, _ := result.state()
+
+	historiesLock.Lock()
+	h, ok := histories[checkID]
+	if !ok {
+		h = &checkHistory{}
+		histories[checkID] = h
+	}
+
+	h.Runs = append(h.Runs, historyRun{
+		Time:     result.LastRun,
+		State:    newState,
+		Success:  result.IsSuccess,
+		Duration: duration.Seconds(),
+		Reason:   result.Reason,
+		Error:    result.LastError,
+	})
+
+	// Without a previous state (first run or restart without state file)
+	// there is nothing to transition from
+	if oldState != "" && newState != oldState {
+		h.Transitions = append(h.Transitions, historyTransition{
+			Time:     result.LastRun,
+			OldState: oldState,
+			NewState: newState,
+			Streak:   result.Streak,
+			Reason:   result.Reason,
+		})
+	}
+
+	h.trim(cfg.HistorySize)
+	historiesLock.Unlock()
+
+	historySnapshot.markChanged()
+}
+
+// trim drops the oldest entries exceeding the given size
+func (h *checkHistory) trim(size int) {
+	if len(h.Runs) > size {
+		h.Runs = append([]historyRun{}, h.Runs[len(h.Runs)-size:]...)
+	}
+	if len(h.Transitions) > size {
+		h.Transitions = append([]historyTransition{}, h.Transitions[len(h.Transitions)-size:]...)
+	}
+}
+
+// forgetHistory removes the history of a check which is no longer defined
+func forgetHistory(checkID string) {
+	historiesLock.Lock()
+	delete(histories, checkID)
+	historiesLock.Unlock()
+
+	historySnapshot.markChanged()
+}
+
+// summarize returns the entries of the history since the given time
+// (zero = all entries) with the number of transitions and the time
+// spent in each state within that window
+func (h checkHistory) summarize(since, now time.Time, currentState string) apiCheckHistory {
+	out := apiCheckHistory{
+		State:       currentState,
+		TimeInState: map[string]float64{},
+		Transitions: []historyTransition{},
+		Runs:        []historyRun{},
+	}
+
+	// Nothing is known about the time before the oldest entry
+	oldest := time.Time{}
+	if len(h.Runs) > 0 {
+		oldest = h.Runs[0].Time
+	}
+	if len(h.Transitions) > 0 && (oldest.IsZero() || h.Transitions[0].Time.Before(oldest)) {
+		oldest = h.Transitions[0].Time
+	}
+	if oldest.IsZero() {
+		return out
+	}
+
+	out.Since = since
+	if since.Before(oldest) {
+		out.Since = oldest
+	}
+
+	for _, r := range h.Runs {
+		if !r.Time.Before(out.Since) {
+			out.Runs = append(out.Runs, r)
+		}
+	}
+
+	state := ""
+	for _, t := range h.Transitions {
+		if t.Time.Before(out.Since) {
+			state = t.NewState
+			continue
+		}
+		out.Transitions = append(out.Transitions, t)
+	}
+
+	if state == "" {
+		state = currentState
+		if len(out.Transitions) > 0 {
+			state = out.Transitions[0].OldState
+		}
+	}
+
+	out.Flaps = len(out.Transitions)
+
+	last := out.Since
+	for _, t := range out.Transitions {
+		out.TimeInState[state] += t.Time.Sub(last).Seconds()
+		state, last = t.NewState, t.Time
+	}
+	out.TimeInState[state] += now.Sub(last).Seconds()
+
+	return out
+}
+
+// checkHistorySummary returns the summarized history of the check
+func checkHistorySummary(checkID string, since time.Time) apiCheckHistory {
+	now := time.Now()
+
+	check, _ := getCheck(checkID)
+	currentState := ""
+	checkResultsLock.RLock()
+	if cr, ok := checkResults[checkID]; ok {
+		currentState, _ = cr.state()
+	}
+	checkResultsLock.RUnlock()
+
+	historiesLock.RLock()
+	h := checkHistory{}
+	if stored, ok := histories[checkID]; ok {
+		h = *stored
+	}
+	historiesLock.RUnlock()
+
+	out := h.summarize(since, now, currentState)
+	out.ID = checkID
+	out.Name = check.Name
+	return out
+}
+
+// historySince reads the `since` query parameter, a duration like 1h
+// limiting the history to the given time before now
+func historySince(r *http.Request) (time.Time, error) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-d), nil
+}
+
+func handleHistory(res http.ResponseWriter, r *http.Request) {
+	since, err := historySince(r)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Invalid value for since: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	ids := getCheckIDs()
+	sort.Strings(ids)
+
+	result := []apiCheckHistory{}
+	for _, id := range ids {
+		result = append(result, checkHistorySummary(id, since))
+	}
+
+	writeJSON(res, result)
+}
+
+func handleCheckHistory(res http.ResponseWriter, r *http.Request) {
+	checkID := mux.Vars(r)["id"]
+
+	since, err := historySince(r)
+	if err != nil {
+		http.Error(res, fmt.Sprintf("Invalid value for since: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := getCheck(checkID); !ok {
+		http.Error(res, fmt.Sprintf("Check %q is not defined", checkID), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(res, checkHistorySummary(checkID, since))
+}
+
+func marshalHistory() ([]byte, error) {
+	file := historyFile{
+		Version: historyFileVersion,
+		SavedAt: time.Now(),
+		Checks:  map[string]*checkHistory{},
+	}
+
+	historiesLock.RLock()
+	defer historiesLock.RUnlock()
+
+	for id, h := range histories {
+		file.Checks[id] = h
+	}
+	return json.Marshal(file)
+}
+
+// restoreHistory loads the history stored in the history file, entries
+// of checks which are no longer defined are discarded
+func restoreHistory() error {
+	file := historyFile{}
+	if ok, err := historySnapshot.read(&file); !ok || err != nil {
+		return err
+	}
+
+	historiesLock.Lock()
+	defer historiesLock.Unlock()
+
+	for id, h := range file.Checks {
+		if _, ok := getCheck(id); !ok || h == nil {
+			continue
+		}
+
+		h.trim(cfg.HistorySize)
+		histories[id] = h
+	}
+
+	logger.WithFields(logFields{"checks": len(histories)}).Infof("Restored history of checks from history file")
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+func TestHistorySummary(t *testing.T) {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	h := checkHistory{
+		Runs: []historyRun{
+			{Time: at(0), State: "PASS", Success: true},
+			{Time: at(10), State: "CRIT"},
+			{Time: at(15), State: "PASS", Success: true},
+			{Time: at(40), State: "CRIT"},
+		},
+		Transitions: []historyTransition{
+			{Time: at(10), OldState: "PASS", NewState: "CRIT"},
+			{Time: at(15), OldState: "CRIT", NewState: "PASS"},
+			{Time: at(40), OldState: "PASS", NewState: "CRIT"},
+		},
+	}
+
+	s := h.summarize(time.Time{}, at(60), "CRIT")
+	if !s.Since.Equal(at(0)) || s.Flaps != 3 || len(s.Runs) != 4 {
+		t.Fatalf("Unexpected summary of full history: %+v", s)
+	}
+	if s.TimeInState["PASS"] != 35*60 || s.TimeInState["CRIT"] != 25*60 {
+		t.Errorf("Unexpected time in state: %v", s.TimeInState)
+	}
+
+	s = h.summarize(at(12), at(60), "CRIT")
+	if s.Flaps != 2 || len(s.Runs) != 2 {
+		t.Fatalf("Unexpected summary since minute 12: %+v", s)
+	}
+	if s.TimeInState["CRIT"] != 3*60+20*60 || s.TimeInState["PASS"] != 25*60 {
+		t.Errorf("Unexpected time in state since minute 12: %v", s.TimeInState)
+	}
+
+	if s = (checkHistory{}).summarize(time.Time{}, at(60), "PASS"); s.Flaps != 0 || len(s.TimeInState) != 0 {
+		t.Errorf("Unexpected summary of empty history: %+v", s)
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	cfg.HistorySize = 3
+	cfg.HistoryFile = ""
+	histories = map[string]*checkHistory{}
+
+	for i, success := range []bool{true, false, false, true, true} {
+		recordHistory("docker", map[bool]string{true: "CRIT", false: "PASS"}[success], checkResult{
+			IsSuccess: success,
+			Streak:    1,
+			LastRun:   time.Unix(int64(i), 0),
+		}, time.Second)
+	}
+
+	h := histories["docker"]
+	if len(h.Runs) != 3 || h.Runs[0].Time.Unix() != 2 {
+		t.Errorf("History is not bounded to the last 3 runs: %+v", h.Runs)
+	}
+	if len(h.Transitions) != 3 {
+		t.Errorf("Expected transitions to be bounded to 3, got %d", len(h.Transitions))
+	}
+}
+
+func TestHistoryPersistence(t *testing.T) {
+	cfg.HistorySize = 10
+	cfg.HistoryFile = filepath.Join(t.TempDir(), "history.json")
+	defer func() { cfg.HistoryFile = "" }()
+
+	checksLock.Lock()
+	checks = map[string]checkCommand{"docker": {Name: "Docker is running"}}
+	checksLock.Unlock()
+
+	histories = map[string]*checkHistory{
+		"docker":  {Runs: []historyRun{{Time: time.Unix(1, 0).UTC(), State: "PASS", Success: true}}},
+		"removed": {Runs: []historyRun{{Time: time.Unix(1, 0).UTC(), State: "PASS", Success: true}}},
+	}
+
+	if err := historySnapshot.write(); err != nil {
+		t.Fatalf("Writing history failed: %s", err)
+	}
+
+	histories = map[string]*checkHistory{}
+	if err := restoreHistory(); err != nil {
+		t.Fatalf("Restoring history failed: %s", err)
+	}
+
+	if len(histories) != 1 || histories["docker"] == nil || len(histories["docker"].Runs) != 1 {
+		t.Errorf("Unexpected restored history: %+v", histories)
+	}
+}
+
+func TestHistoryAfterRestart(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{
+		"docker": {Name: "Docker is running", Command: commandLine{Script: "false"}},
+	})
+	cfg.HistorySize = 10
+	cfg.HistoryFile = filepath.Join(t.TempDir(), "history.json")
+	defer func() { cfg.HistorySize, cfg.HistoryFile = 0, "" }()
+
+	// The history was persisted but the check results were not, so the
+	// state of the check before the restart is unknown
+	histories = map[string]*checkHistory{
+		"docker": {Runs: []historyRun{{Time: time.Now().Add(-time.Minute).UTC(), State: "PASS", Success: true}}},
+	}
+	if err := historySnapshot.write(); err != nil {
+		t.Fatalf("Writing history failed: %s", err)
+	}
+	histories = map[string]*checkHistory{}
+	if err := restoreHistory(); err != nil {
+		t.Fatalf("Restoring history failed: %s", err)
+	}
+
+	executeAndRegisterCheck(context.Background(), "docker")
+
+	historiesLock.RLock()
+	h := histories["docker"]
+	if len(h.Runs) != 2 || len(h.Transitions) != 0 {
+		t.Errorf("Expected run without transition from unknown state, got %+v", h)
+	}
+	historiesLock.RUnlock()
+
+	checksLock.Lock()
+	checks["docker"] = checkCommand{Name: "Docker is running", Command: commandLine{Script: "true"}}
+	checksLock.Unlock()
+
+	executeAndRegisterCheck(context.Background(), "docker")
+
+	historiesLock.RLock()
+	defer historiesLock.RUnlock()
+	if len(h.Transitions) != 1 || h.Transitions[0].OldState != "CRIT" || h.Transitions[0].NewState != "PASS" {
+		t.Errorf("Expected transition from known state, got %+v", h.Transitions)
+	}
+}
+
+func TestHistoryHandlers(t *testing.T) {
+	cfg.HistorySize = 10
+
+	checksLock.Lock()
+	checks = map[string]checkCommand{"docker": {Name: "Docker is running"}}
+	checksLock.Unlock()
+
+	histories = map[string]*checkHistory{
+		"docker": {Runs: []historyRun{{Time: time.Now().Add(-2 * time.Hour), State: "PASS", Success: true}}},
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/history", handleHistory)
+	r.HandleFunc("/checks/{id}/history", handleCheckHistory)
+
+	for path, code := range map[string]int{
+		"/history":                        http.StatusOK,
+		"/history?since=foo":              http.StatusBadRequest,
+		"/checks/docker/history":          http.StatusOK,
+		"/checks/unknown/history":         http.StatusNotFound,
+		"/checks/docker/history?since=1h": http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != code {
+			t.Errorf("Expected status %d for %s, got %d", code, path, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/checks/docker/history?since=1h", nil))
+
+	var res apiCheckHistory
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Response is no valid JSON: %s", err)
+	}
+	if res.ID != "docker" || res.Name != "Docker is running" || len(res.Runs) != 0 {
+		t.Errorf("Unexpected response: %+v", res)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	imdsTimeout  = 2 * time.Second
+	imdsTokenTTL = 6 * time.Hour
+)
+
+var metadataClient = &imdsClient{}
+
+// imdsClient accesses the EC2 instance metadata service using IMDSv2
+// session tokens
+type imdsClient struct {
+	lock sync.Mutex
+
+	token       string
+	tokenExpiry time.Time
+}
+
+func (i *imdsClient) getToken(client *http.Client) (string, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if i.token != "" && time.Now().Before(i.tokenExpiry) {
+		return i.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodPut, strings.TrimRight(cfg.IMDSEndpoint, "/")+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", fmt.Sprintf("%d", int(imdsTokenTTL.Seconds())))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected HTTP status %d requesting metadata token", resp.StatusCode)
+	}
+
+	token, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// Refresh the token a bit before it expires
+	i.token = string(token)
+	i.tokenExpiry = time.Now().Add(imdsTokenTTL - time.Minute)
+
+	return i.token, nil
+}
+
+// get fetches the given path below /latest/ from the metadata service
+func (i *imdsClient) get(path string) (string, error) {
+	client := &http.Client{Timeout: imdsTimeout}
+
+	token, err := i.getToken(client)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(cfg.IMDSEndpoint, "/")+"/latest/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Token was invalidated, request a new one on next call
+		i.lock.Lock()
+		i.token = ""
+		i.lock.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fetchError{Resource: fmt.Sprintf("metadata %q", path), StatusCode: resp.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func (i *imdsClient) getJSON(path string, v interface{}) error {
+	body, err := i.get(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(body), v)
+}
+
+// awsRegion returns the configured region or the region the instance is
+// running in
+func awsRegion() (string, error) {
+	if cfg.AWSRegion != "" {
+		return cfg.AWSRegion, nil
+	}
+
+	region, err := metadataClient.get("meta-data/placement/region")
+	if err != nil {
+		return "", fmt.Errorf("Unable to determine AWS region: %s", err)
+	}
+	return region, nil
+}
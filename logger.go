@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+var logLevelNames = map[logLevel]string{
+	logLevelDebug: "debug",
+	logLevelInfo:  "info",
+	logLevelWarn:  "warn",
+	logLevelError: "error",
+}
+
+// logFields contains additional information attached to a log entry
+type logFields map[string]interface{}
+
+// structuredLogger writes log entries having a level and fields in one
+// of the formats text (human readable), logfmt or json
+type structuredLogger struct {
+	out    io.Writer
+	format string
+	level  logLevel
+	fields logFields
+	now    func() time.Time
+
+	lock *sync.Mutex
+}
+
+// logger is used for all log output of the daemon, it is replaced by
+// initLogging as soon as the configuration is known
+var logger = newStructuredLogger(os.Stderr, "text", logLevelInfo)
+
+func newStructuredLogger(out io.Writer, format string, level logLevel) *structuredLogger {
+	return &structuredLogger{
+		out:    out,
+		format: format,
+		level:  level,
+		fields: logFields{},
+		now:    time.Now,
+		lock:   new(sync.Mutex),
+	}
+}
+
+// initLogging configures the logger from the config and sends messages
+// of the standard logger (for example from libraries) through it
+func initLogging() error {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	switch cfg.LogFormat {
+	case "text", "logfmt", "json":
+	default:
+		return fmt.Errorf("Unknown log format %q", cfg.LogFormat)
+	}
+
+	logger = newStructuredLogger(os.Stderr, cfg.LogFormat, level)
+
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+
+	return nil
+}
+
+func parseLogLevel(in string) (logLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(in, name) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level %q", in)
+}
+
+// WithFields returns a logger adding the given fields to all entries
+func (l *structuredLogger) WithFields(fields logFields) *structuredLogger {
+	n := *l
+	n.fields = logFields{}
+	for k, v := range l.fields {
+		n.fields[k] = v
+	}
+	for k, v := range fields {
+		n.fields[k] = v
+	}
+	return &n
+}
+
+func (l *structuredLogger) Debugf(format string, args ...interface{}) {
+	l.log(logLevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *structuredLogger) Infof(format string, args ...interface{}) {
+	l.log(logLevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *structuredLogger) Warnf(format string, args ...interface{}) {
+	l.log(logLevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *structuredLogger) Errorf(format string, args ...interface{}) {
+	l.log(logLevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs the message and exits the daemon
+func (l *structuredLogger) Fatalf(format string, args ...interface{}) {
+	l.log(logLevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *structuredLogger) log(level logLevel, msg string) {
+	if level < l.level {
+		return
+	}
+
+	keys := []string{}
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := new(bytes.Buffer)
+	now := l.now()
+
+	switch l.format {
+	case "json":
+		buf.WriteString(`{"time":`)
+		writeJSONValue(buf, now.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSONValue(buf, logLevelNames[level])
+		buf.WriteString(`,"msg":`)
+		writeJSONValue(buf, msg)
+		for _, k := range keys {
+			buf.WriteByte(',')
+			writeJSONValue(buf, k)
+			buf.WriteByte(':')
+			writeJSONValue(buf, jsonLogValue(l.fields[k]))
+		}
+		buf.WriteString("}\n")
+
+	case "logfmt":
+		fmt.Fprintf(buf, "time=%s level=%s msg=%s", now.Format(time.RFC3339Nano), logLevelNames[level], logfmtValue(msg))
+		for _, k := range keys {
+			fmt.Fprintf(buf, " %s=%s", k, logfmtValue(textLogValue(l.fields[k])))
+		}
+		buf.WriteByte('\n')
+
+	default:
+		fmt.Fprintf(buf, "%s %s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(logLevelNames[level]), msg)
+		for _, k := range keys {
+			fmt.Fprintf(buf, " %s=%s", k, logfmtValue(textLogValue(l.fields[k])))
+		}
+		buf.WriteByte('\n')
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// jsonLogValue converts values which would not be encoded usefully,
+// durations are logged in seconds
+func jsonLogValue(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case error:
+		return tv.Error()
+	case time.Duration:
+		return tv.Seconds()
+	case time.Time:
+		return tv.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return tv.String()
+	}
+	return v
+}
+
+func textLogValue(v interface{}) string {
+	switch tv := v.(type) {
+	case error:
+		return tv.Error()
+	case time.Time:
+		return tv.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// logfmtValue quotes the value if required to keep the line parseable
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\t\r\n\\") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// stdLogWriter passes messages of the standard logger to the logger
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	logger.Infof("%s", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// logRequests logs all requests to the handler on debug level
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+
+		h.ServeHTTP(rec, r)
+
+		logger.WithFields(logFields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      rec.status,
+			"duration":    time.Since(start),
+			"remote_addr": r.RemoteAddr,
+		}).Debugf("Handled request")
+	})
+}
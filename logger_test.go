@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStructuredLoggerFormats(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	fields := logFields{
+		"check_id": "docker",
+		"streak":   3,
+		"duration": 1500 * time.Millisecond,
+		"error":    errors.New("exit status 1"),
+	}
+
+	for format, expect := range map[string]string{
+		"text":   "2017/03/01 12:00:00 WARN Check failed check_id=docker duration=1.5s error=\"exit status 1\" streak=3\n",
+		"logfmt": "time=2017-03-01T12:00:00Z level=warn msg=\"Check failed\" check_id=docker duration=1.5s error=\"exit status 1\" streak=3\n",
+		"json":   `{"time":"2017-03-01T12:00:00Z","level":"warn","msg":"Check failed","check_id":"docker","duration":1.5,"error":"exit status 1","streak":3}` + "\n",
+	} {
+		buf := new(bytes.Buffer)
+		l := newStructuredLogger(buf, format, logLevelInfo)
+		l.now = func() time.Time { return now }
+
+		l.WithFields(fields).Warnf("Check failed")
+
+		if buf.String() != expect {
+			t.Errorf("Unexpected %s output:\n%s\nexpected:\n%s", format, buf.String(), expect)
+		}
+	}
+}
+
+func TestStructuredLoggerLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := newStructuredLogger(buf, "json", logLevelWarn)
+
+	l.Infof("not logged")
+	l.Debugf("not logged")
+	if buf.Len() != 0 {
+		t.Fatalf("Messages below the level were logged: %s", buf.String())
+	}
+
+	l.WithFields(logFields{"check_id": "docker"}).Errorf("logged %d", 1)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Log output is no valid JSON: %s", err)
+	}
+	if entry["level"] != "error" || entry["msg"] != "logged 1" || entry["check_id"] != "docker" {
+		t.Errorf("Unexpected log entry: %v", entry)
+	}
+
+	if len(l.fields) != 0 {
+		t.Errorf("WithFields modified the parent logger")
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	if level, err := parseLogLevel("DEBUG"); err != nil || level != logLevelDebug {
+		t.Errorf("Expected debug level, got %v (%v)", level, err)
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Errorf("Expected unknown level to be rejected")
+	}
+}
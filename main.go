@@ -2,16 +2,14 @@ package main
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -21,41 +19,183 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron"
 	"golang.org/x/net/context"
-	"gopkg.in/yaml.v2"
 )
 
 var (
 	cfg = struct {
-		CheckDefinitionsFile string `flag:"check-definitions-file,c" default:"/etc/elb-instance-status.yml" description:"File or URL containing checks to perform for instance health"`
-		UnhealthyThreshold   int64  `flag:"unhealthy-threshold" default:"5" description:"How often does a check have to fail to mark the machine unhealthy"`
+		CheckDefinitionsFiles []string `flag:"check-definitions-file,c" default:"/etc/elb-instance-status.yml" description:"Files, directories, glob patterns or URLs containing checks to perform for instance health (merged in order)"`
+		DuplicateChecks       string   `flag:"duplicate-checks" default:"override" description:"How to handle checks defined in multiple sources (override, error)"`
+		TemplateDefinitions   bool     `flag:"template-definitions" default:"false" description:"Render definitions as Go templates having access to environment and instance metadata"`
+
+		AWSRegion          string `flag:"aws-region" default:"" env:"AWS_REGION" description:"AWS region to use (defaults to the region of the instance)"`
+		IMDSEndpoint       string `flag:"imds-endpoint" default:"http://169.254.169.254" env:"AWS_EC2_METADATA_SERVICE_ENDPOINT" description:"Endpoint of the EC2 instance metadata service"`
+		S3Endpoint         string `flag:"s3-endpoint" default:"" description:"Use a custom S3 compatible endpoint with path-style addressing for s3:// sources"`
+		UnhealthyThreshold int64  `flag:"unhealthy-threshold" default:"5" description:"How often does a check have to fail to mark the machine unhealthy"`
 
 		CheckInterval         time.Duration `flag:"check-interval" default:"1m" description:"How often to execute checks (do not set below 10s!)"`
+		KillGracePeriod       time.Duration `flag:"kill-grace-period" default:"5s" description:"How long to wait for a timed out check to exit after SIGTERM before sending SIGKILL"`
+		OutputWaitDelay       time.Duration `flag:"output-wait-delay" default:"1s" description:"How long to wait for remaining output after a check exited before closing its pipes"`
+		MaxConcurrentChecks   int           `flag:"max-concurrent-checks" default:"0" description:"How many checks to execute at the same time (0 = unlimited)"`
+		CheckSplay            time.Duration `flag:"check-splay" default:"0s" description:"Delay the start of each check by a random duration up to this value"`
+		CheckJitter           time.Duration `flag:"check-jitter" default:"0s" description:"Delay the check schedule by a random duration up to this value on start to spread checks across a fleet"`
+		StateFile             string        `flag:"state-file" default:"" description:"File to persist check results in to restore them after a restart"`
+		StateMaxAge           time.Duration `flag:"state-max-age" default:"1h" description:"Discard persisted check results older than this"`
 		ConfigRefreshInterval time.Duration `flag:"config-refresh" default:"10m" description:"How often to update checks from definitions file / url"`
 
-		Verbose bool `flag:"verbose,v" default:"false" description:"Attach stdout of the executed commands"`
+		DefinitionsCacheDir     string        `flag:"definitions-cache-dir" default:"" description:"Directory to store the last valid definitions fetched from URLs in to use them when the URLs are unreachable"`
+		DefinitionsFetchRetries int           `flag:"definitions-fetch-retries" default:"3" description:"How often to retry fetching definitions from an URL before giving up"`
+		DefinitionsFetchTimeout time.Duration `flag:"definitions-fetch-timeout" default:"10s" description:"Timeout for a single request fetching definitions from an URL"`
+		DefinitionsVerify       string        `flag:"definitions-verify" default:"none" description:"How to verify definitions fetched from an URL (none, sha256, ed25519)"`
+		DefinitionsChecksums    string        `flag:"definitions-checksums" default:"" description:"File containing the SHA-256 checksums of the definitions URLs in sha256sum format (URL as file name)"`
+		DefinitionsPublicKeys   []string      `flag:"definitions-public-key" default:"" description:"Files containing base64 encoded Ed25519 public keys trusted to sign definitions"`
+
+		ReportASGHealth     bool   `flag:"report-asg-health" default:"false" description:"Mark the instance unhealthy in its Auto Scaling group when it becomes unhealthy"`
+		HealthTag           string `flag:"health-tag" default:"" description:"Tag the EC2 instance with its health using this tag key (requires --report-asg-health)"`
+		AutoscalingEndpoint string `flag:"autoscaling-endpoint" default:"" description:"Use a custom endpoint for the Auto Scaling API"`
+		EC2Endpoint         string `flag:"ec2-endpoint" default:"" description:"Use a custom endpoint for the EC2 API"`
+
+		HistorySize int    `flag:"history-size" default:"100" description:"How many runs and state transitions to keep per check (0 = disable history)"`
+		HistoryFile string `flag:"history-file" default:"" description:"File to persist the history of the checks in to restore it after a restart"`
+
+		PushgatewayURL string        `flag:"pushgateway-url" default:"" description:"URL of a Prometheus Pushgateway to push the metrics to"`
+		PushInterval   time.Duration `flag:"push-interval" default:"1m" description:"How often to push the metrics to the Pushgateway"`
+		PushJob        string        `flag:"push-job" default:"elb-instance-status" description:"Job name to push the metrics with"`
+
+		MetricsBuckets   []string `flag:"metrics-buckets" default:"0.05,0.1,0.25,0.5,1,2.5,5,10,30,60" description:"Upper bounds in seconds of the buckets of the duration histograms"`
+		MetricsLegacy    bool     `flag:"metrics-legacy" default:"false" description:"Additionally export the metrics check_passing, check_execution_time and status_code of previous versions"`
+		MetricsLabels    []string `flag:"metrics-label" default:"" description:"Constant labels (key=value) to attach to all metrics, values may use the definitions template functions"`
+		MetricsNamespace string   `flag:"metrics-namespace" default:"elb_instance_status" description:"Prefix of the metric names"`
+		MetricsRuntime   bool     `flag:"metrics-runtime" default:"true" description:"Export Go runtime and process metrics"`
+
+		WebhookURLs     []string `flag:"webhook-url" default:"" description:"URLs to send notifications about check state and health changes to"`
+		WebhookFormat   string   `flag:"webhook-format" default:"generic" description:"Format of the webhook payload (generic, slack)"`
+		WebhookTemplate string   `flag:"webhook-template" default:"" description:"File containing a Go template to render the webhook payload with (overrides webhook-format)"`
+		WebhookRetries  int      `flag:"webhook-retries" default:"3" description:"How often to retry sending a webhook before giving up"`
+
+		Verbose   bool   `flag:"verbose,v" default:"false" description:"Attach stdout of the executed commands"`
+		LogFormat string `flag:"log-format" default:"text" description:"Format of the log output (text, logfmt, json)"`
+		LogLevel  string `flag:"log-level" default:"info" description:"Minimum level of log messages to print (debug, info, warn, error)"`
+
+		CheckLogMaxLine int    `flag:"check-log-max-line" default:"4096" description:"Truncate lines of check output longer than this many bytes in the log (0 = unlimited)"`
+		CheckLogRate    int    `flag:"check-log-rate" default:"50" description:"How many lines of output per second of a single check to log (0 = unlimited)"`
+		CheckLogBurst   int    `flag:"check-log-burst" default:"200" description:"How many lines of output a check may log at once before the rate limit applies"`
+		CheckLogStderr  string `flag:"check-log-stderr" default:"log" description:"Where to send stderr of the checks (log, journald, syslog, none)"`
+		CheckLogStdout  string `flag:"check-log-stdout" default:"none" description:"Where to send stdout of the checks (log, journald, syslog, none), --verbose sends it to the log"`
+		JournaldSocket  string `flag:"journald-socket" default:"/run/systemd/journal/socket" description:"Socket of journald to send check output to"`
+		SyslogAddress   string `flag:"syslog-address" default:"unix:///dev/log" description:"Syslog server to send check output to (unix:///path, udp://host:port, tcp://host:port)"`
+		SyslogFacility  string `flag:"syslog-facility" default:"daemon" description:"Syslog facility to send check output with"`
+		SyslogTag       string `flag:"syslog-tag" default:"elb-instance-status" description:"Identifier to send check output to journald and syslog with"`
+
+		Shell        string `flag:"shell" default:"bash" description:"Shell to execute check commands with if not set for the check (bash, sh or any command accepting -c)"`
+		CgroupParent string `flag:"cgroup-parent" default:"" description:"Delegated cgroup v2 directory to create cgroups for memory and CPU limits of the checks in"`
 
 		Listen         string `flag:"listen" default:":3000" description:"IP/Port to listen on for ELB health checks"`
+		APIToken       string `flag:"api-token" default:"" env:"API_TOKEN" description:"Bearer token required to access the checks API (API is disabled if empty)"`
 		VersionAndExit bool   `flag:"version" default:"false" description:"Print version and exit"`
 	}{}
 
 	version = "dev"
 
 	checks               map[string]checkCommand
+	checksLock           sync.RWMutex
 	checkResults         = map[string]*checkResult{}
 	checkResultsLock     sync.RWMutex
 	lastResultRegistered time.Time
+
+	// lastVerdictHealthy contains the overall health after the last
+	// registered result, it is protected by checkResultsLock
+	lastVerdictHealthy = true
+
+	runningChecks     = map[string]*checkRun{}
+	runningChecksLock sync.Mutex
 )
 
 type checkCommand struct {
-	Name     string `yaml:"name"`
-	Command  string `yaml:"command"`
-	WarnOnly bool   `yaml:"warn-only"`
+	Name     string      `yaml:"name"`
+	Command  commandLine `yaml:"command"`
+	Shell    string      `yaml:"shell"`
+	WarnOnly bool        `yaml:"warn-only"`
+	Tags     []string    `yaml:"tags"`
+
+	Env      map[string]string `yaml:"env"`
+	ClearEnv bool              `yaml:"clear-env"`
+	Workdir  string            `yaml:"workdir"`
+	User     string            `yaml:"user"`
+	Group    string            `yaml:"group"`
+
+	MaxMemory string `yaml:"max-memory"`
+	CPUQuota  string `yaml:"cpu-quota"`
+	Nice      int    `yaml:"nice"`
+	IONice    string `yaml:"ionice"`
+	MaxOutput string `yaml:"max-output"`
+
+	Splay time.Duration `yaml:"splay"`
+
+	Remediate *remediation `yaml:"remediate"`
+
+	// Source contains the file or URL the check was loaded from
+	Source string `yaml:"-"`
+}
+
+func (c checkCommand) hasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
 type checkResult struct {
 	Check     checkCommand
 	IsSuccess bool
 	Streak    int64
+
+	LastRun    time.Time
+	LastError  string
+	LastOutput string
+	// Reason contains why the check failed if it did not fail by exiting
+	// with a non-zero status, for example because it exceeded a limit
+	Reason string
+
+	// UncountedStreak contains the number of failures in the current
+	// streak which do not count towards the unhealthy threshold as the
+	// check was still being remediated
+	UncountedStreak int64
+	Remediation     remediationState
+}
+
+// state returns the textual state of the check result and whether the
+// result causes the instance to be marked unhealthy
+func (cr checkResult) state() (string, bool) {
+	switch {
+	case cr.IsSuccess:
+		return "PASS", false
+	case cr.Check.WarnOnly:
+		return "WARN", false
+	case cr.Streak-cr.UncountedStreak < cfg.UnhealthyThreshold:
+		return "CRIT", false
+	default:
+		return "CRIT", true
+	}
+}
+
+// isHealthy returns whether no check result causes the instance to be
+// marked unhealthy, checkResultsLock must be held by the caller
+func isHealthy() bool {
+	for _, cr := range checkResults {
+		if _, unhealthy := cr.state(); unhealthy {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRun represents a single execution of a check which might be
+// waited for by multiple callers
+type checkRun struct {
+	done   chan struct{}
+	result checkResult
 }
 
 func init() {
@@ -67,174 +207,384 @@ func init() {
 	}
 }
 
-func loadChecks() error {
-	var rawChecks []byte
+// getCheck returns the current definition of the given check
+func getCheck(checkID string) (checkCommand, bool) {
+	checksLock.RLock()
+	defer checksLock.RUnlock()
 
-	if _, err := os.Stat(cfg.CheckDefinitionsFile); err == nil {
-		// We got a local file, read it
-		rawChecks, err = ioutil.ReadFile(cfg.CheckDefinitionsFile)
-		if err != nil {
-			return err
-		}
-	} else {
-		// Check whether we got an URL
-		if _, err := url.Parse(cfg.CheckDefinitionsFile); err != nil {
-			return errors.New("Definitions file is neither a local file nor a URL")
-		}
+	check, ok := checks[checkID]
+	return check, ok
+}
 
-		// We got an URL, fetch and read it
-		resp, err := http.Get(cfg.CheckDefinitionsFile)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-		rawChecks, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
+// getCheckIDs returns the IDs of all currently defined checks
+func getCheckIDs() []string {
+	checksLock.RLock()
+	defer checksLock.RUnlock()
+
+	ids := []string{}
+	for id := range checks {
+		ids = append(ids, id)
 	}
+	return ids
+}
 
-	tmpResult := map[string]checkCommand{}
-	err := yaml.Unmarshal(rawChecks, &tmpResult)
+func main() {
+	if err := initLogging(); err != nil {
+		log.Fatalf("Invalid logging config: %s", err)
+	}
 
-	if err == nil {
-		checks = tmpResult
+	var err error
+	if metricsRegistry, err = newMetricsRegistry(); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Unable to initialize metrics")
 	}
 
-	return err
-}
+	if err := initCheckLogSinks(); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Unable to initialize check output sinks")
+	}
 
-func main() {
-	if err := loadChecks(); err != nil {
-		log.Fatalf("Unable to read definitions file: %s", err)
+	if err := initOTel(); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Unable to initialize OpenTelemetry export")
+	}
+
+	if err := initWebhooks(); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Invalid webhook config")
+	}
+
+	if err := validateSplay(cfg.CheckSplay); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Invalid --check-splay")
+	}
+
+	if err := validateDuplicateChecks(); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Invalid definitions config")
+	}
+
+	if err := validateVerifyConfig(); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Invalid definitions verification config")
+	}
+
+	if err := reloadChecks("startup"); err != nil {
+		logger.WithFields(logFields{"error": err}).Fatalf("Unable to read definitions file")
+	}
+
+	if cfg.StateFile != "" {
+		if err := restoreState(); err != nil {
+			logger.WithFields(logFields{"error": err}).Errorf("Unable to restore state")
+		}
+		go stateSnapshot.run()
+	}
+
+	if cfg.HistoryFile != "" {
+		if err := restoreHistory(); err != nil {
+			logger.WithFields(logFields{"error": err}).Errorf("Unable to restore history")
+		}
+		go historySnapshot.run()
 	}
 
 	c := cron.New()
-	c.AddFunc("@every "+cfg.CheckInterval.String(), spawnChecks)
 	c.AddFunc("@every "+cfg.ConfigRefreshInterval.String(), func() {
-		if err := loadChecks(); err != nil {
-			log.Printf("Unable to refresh checks: %s", err)
+		if err := reloadChecks("refresh"); err != nil {
+			logger.WithFields(logFields{"error": err}).Errorf("Unable to refresh checks")
 		}
 	})
 	c.Start()
 
-	spawnChecks()
+	go reloadOnSignal()
+	go func() {
+		if err := watchDefinitionsFile(); err != nil {
+			logger.WithFields(logFields{"error": err}).Errorf("Unable to watch definitions file for changes")
+		}
+	}()
+
+	initCheckSlots()
+	initHealthReporters()
+	go scheduleChecks()
+
+	shutdownHooks := []func(){}
+	if cfg.PushgatewayURL != "" {
+		pusher := newMetricsPusher()
+		go pusher.run()
+		shutdownHooks = append(shutdownHooks, pusher.shutdown)
+	}
+
+	if otelExporter != nil {
+		shutdownHooks = append(shutdownHooks, otelExporter.shutdown)
+	}
 
 	r := mux.NewRouter()
-	r.HandleFunc("/status", handleELBHealthCheck)
-	r.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(cfg.Listen, r)
+	r.HandleFunc("/status", traceRequest("/status", handleELBHealthCheck))
+	r.HandleFunc("/status/check/{id}", traceRequest("/status/check/{id}", handleCheckHealthCheck))
+	r.HandleFunc("/status/tag/{tag}", traceRequest("/status/tag/{tag}", handleTagHealthCheck))
+	r.HandleFunc("/history", traceRequest("/history", handleHistory)).Methods(http.MethodGet)
+	r.HandleFunc("/checks/{id}/history", traceRequest("/checks/{id}/history", handleCheckHistory)).Methods(http.MethodGet)
+	r.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	registerAPIRoutes(r)
+
+	srv := &http.Server{Addr: cfg.Listen, Handler: logRequests(r)}
+	shutdownDone := make(chan struct{})
+	go shutdownOnSignal(srv, shutdownHooks, shutdownDone)
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		logger.WithFields(logFields{"error": err}).Fatalf("Unable to listen for HTTP requests")
+	}
+	<-shutdownDone
 }
 
-func spawnChecks() {
-	ctx, _ := context.WithTimeout(context.Background(), cfg.CheckInterval-time.Second)
+// shutdownOnSignal stops the HTTP server after executing the hooks when
+// the daemon is asked to terminate
+func shutdownOnSignal(srv *http.Server, hooks []func(), done chan struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
 
-	for id := range checks {
-		go executeAndRegisterCheck(ctx, id)
+	logger.Infof("Shutting down")
+	for _, hook := range hooks {
+		hook()
 	}
-}
 
-func executeAndRegisterCheck(ctx context.Context, checkID string) {
-	check := checks[checkID]
-	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
 
-	cmd := exec.Command("/bin/bash", "-e", "-o", "pipefail", "-c", check.Command)
+	close(done)
+}
 
-	// Enable process groups in to order to be able to kill a whole group
-	// instead of a single process
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+func spawnChecks() {
+	for _, id := range getCheckIDs() {
+		check, _ := getCheck(id)
+		runCheck(id, check.startDelay())
+	}
+}
 
-	cmd.Stderr = newPrefixedLogger(os.Stderr, checkID+":STDERR")
-	if cfg.Verbose {
-		cmd.Stdout = newPrefixedLogger(os.Stderr, checkID+":STDOUT")
+// runCheck starts the execution of the given check after the delay unless
+// it is already running in which case the already running execution is
+// returned. This ensures a check is never executed concurrently to itself.
+// Waiting for an execution slot and the execution itself are limited to
+// the check timeout each, both starting after the delay.
+func runCheck(checkID string, delay time.Duration) *checkRun {
+	runningChecksLock.Lock()
+	defer runningChecksLock.Unlock()
+
+	if run, ok := runningChecks[checkID]; ok {
+		return run
 	}
-	err := cmd.Start()
 
-	if err == nil {
-		cmdDone := make(chan error)
-		go func(cmdDone chan error, cmd *exec.Cmd) { cmdDone <- cmd.Wait() }(cmdDone, cmd)
-		loop := true
-		for loop {
-			select {
-			case err = <-cmdDone:
-				loop = false
-			case <-ctx.Done():
-				log.Printf("Execution of check '%s' was killed through context timeout.", checkID)
+	run := &checkRun{done: make(chan struct{})}
+	runningChecks[checkID] = run
 
-				// Kill the process group to make sure that all child processes are killed too
-				// and to avoid deadlocks.
-				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	go func() {
+		if delay > 0 {
+			time.Sleep(delay)
+		}
 
-				time.Sleep(100 * time.Millisecond)
-			}
+		waitCtx, cancelWait := context.WithTimeout(context.Background(), checkTimeout())
+		if waitForSlot(waitCtx) {
+			ctx, cancel := context.WithTimeout(context.Background(), checkTimeout())
+			run.result = executeAndRegisterCheck(ctx, checkID)
+			cancel()
+			releaseSlot()
+		} else {
+			logger.WithFields(logFields{"check_id": checkID}).Warnf("Check was skipped as it did not get an execution slot in time")
+			run.result = currentResult(checkID)
 		}
+		cancelWait()
+
+		runningChecksLock.Lock()
+		delete(runningChecks, checkID)
+		runningChecksLock.Unlock()
+
+		close(run.done)
+	}()
+
+	return run
+}
+
+func executeAndRegisterCheck(ctx context.Context, checkID string) checkResult {
+	check, ok := getCheck(checkID)
+	if !ok {
+		// Check was removed while its execution was pending
+		return checkResult{}
 	}
 
+	start := time.Now()
+	output := newOutputTail(maxCapturedOutput)
+
+	reason, err := runCheckCommand(ctx, checkID, check, output)
+	duration := time.Since(start)
+	recordCheckSpan(checkID, check, start, start.Add(duration), reason, err)
+
 	success := err == nil
 
 	checkResultsLock.Lock()
 
-	if _, ok := checkResults[checkID]; !ok {
-		checkResults[checkID] = &checkResult{
-			Check: check,
-		}
+	// The results of removed checks are discarded after the definitions
+	// are replaced, so registering the result of a check removed during
+	// its execution would bring it back
+	if _, ok := getCheck(checkID); !ok {
+		checkResultsLock.Unlock()
+		return checkResult{Check: check}
 	}
 
+	// Checks without previous result are considered passing to notify
+	// about checks failing right from the start. The history only records
+	// transitions from a known state.
+	oldState, knownState := "PASS", ""
+	if cr, ok := checkResults[checkID]; ok {
+		oldState, _ = cr.state()
+		knownState = oldState
+	} else {
+		checkResults[checkID] = &checkResult{}
+	}
+	checkResults[checkID].Check = check
+
 	if success == checkResults[checkID].IsSuccess {
 		checkResults[checkID].Streak++
 	} else {
 		checkResults[checkID].IsSuccess = success
 		checkResults[checkID].Streak = 1
+		checkResults[checkID].UncountedStreak = 0
 	}
 
-	if !success {
-		log.Printf("Check %q failed, streak now at %d, error was: %s", checkID, checkResults[checkID].Streak, err)
+	if success {
+		checkResults[checkID].Remediation.Attempts = 0
+	} else if check.Remediate.hasBudget(checkResults[checkID].Remediation) {
+		checkResults[checkID].UncountedStreak++
 	}
 
-	lastResultRegistered = time.Now()
+	checkResults[checkID].LastRun = start
+	checkResults[checkID].LastOutput = output.String()
+	checkResults[checkID].LastError = ""
+	checkResults[checkID].Reason = reason
 
-	if success {
-		checkPassing.WithLabelValues(checkID).Set(1)
+	if !success {
+		checkResults[checkID].LastError = err.Error()
+		fields := logFields{
+			"check_id": checkID,
+			"streak":   checkResults[checkID].Streak,
+			"duration": duration,
+			"error":    err,
+		}
+		if reason != "" {
+			fields["reason"] = reason
+		}
+		logger.WithFields(fields).Warnf("Check failed")
 	} else {
-		checkPassing.WithLabelValues(checkID).Set(0)
+		logger.WithFields(logFields{
+			"check_id": checkID,
+			"streak":   checkResults[checkID].Streak,
+			"duration": duration,
+		}).Debugf("Check passed")
 	}
-	checkExecutionTime.WithLabelValues(checkID).Observe(float64(time.Since(start).Nanoseconds()) / float64(time.Microsecond))
+
+	lastResultRegistered = time.Now()
+
+	recordCheckRun(checkID, *checkResults[checkID], duration)
+
+	doRemediate := !success && check.Remediate.isDue(checkResults[checkID].Streak, checkResults[checkID].Remediation)
+	result := *checkResults[checkID]
+
+	healthy := isHealthy()
+	verdictChanged := healthy != lastVerdictHealthy
+	lastVerdictHealthy = healthy
+	recordHealth(healthy)
 
 	checkResultsLock.Unlock()
+
+	stateSnapshot.markChanged()
+	recordHistory(checkID, knownState, result, duration)
+
+	if newState, _ := result.state(); newState != oldState {
+		notifyWebhooks(newCheckStateEvent(checkID, oldState, newState, result))
+	}
+	if verdictChanged {
+		notifyWebhooks(newVerdictEvent(healthy, checkID, result))
+		reportHealth(healthy)
+	}
+
+	if doRemediate {
+		result = remediateCheck(checkID, check)
+	}
+
+	return result
 }
 
 func handleELBHealthCheck(res http.ResponseWriter, r *http.Request) {
+	writeHealthStatus(res, func(string, *checkResult) bool { return true })
+}
+
+func handleCheckHealthCheck(res http.ResponseWriter, r *http.Request) {
+	checkID := mux.Vars(r)["id"]
+
+	if _, ok := getCheck(checkID); !ok {
+		http.Error(res, fmt.Sprintf("Check %q is not defined", checkID), http.StatusNotFound)
+		return
+	}
+
+	writeHealthStatus(res, func(id string, _ *checkResult) bool { return id == checkID })
+}
+
+func handleTagHealthCheck(res http.ResponseWriter, r *http.Request) {
+	tag := mux.Vars(r)["tag"]
+
+	found := false
+	checksLock.RLock()
+	for _, check := range checks {
+		if check.hasTag(tag) {
+			found = true
+			break
+		}
+	}
+	checksLock.RUnlock()
+	if !found {
+		http.Error(res, fmt.Sprintf("No checks are tagged with %q", tag), http.StatusNotFound)
+		return
+	}
+
+	writeHealthStatus(res, func(_ string, cr *checkResult) bool { return cr.Check.hasTag(tag) })
+}
+
+// writeHealthStatus renders the state of all check results matching the
+// filter and sets the HTTP status according to their health. It returns
+// whether the selected checks are considered healthy.
+func writeHealthStatus(res http.ResponseWriter, filter func(checkID string, cr *checkResult) bool) bool {
 	healthy := true
 	start := time.Now()
 	buf := bytes.NewBuffer([]byte{})
 
 	checkResultsLock.RLock()
-	for _, cr := range checkResults {
-		state := ""
-		switch {
-		case cr.IsSuccess:
-			state = "PASS"
-		case !cr.IsSuccess && cr.Check.WarnOnly:
-			state = "WARN"
-		case !cr.IsSuccess && !cr.Check.WarnOnly && cr.Streak < cfg.UnhealthyThreshold:
-			state = "CRIT"
-		case !cr.IsSuccess && !cr.Check.WarnOnly && cr.Streak >= cfg.UnhealthyThreshold:
-			state = "CRIT"
+	for id, cr := range checkResults {
+		if !filter(id, cr) {
+			continue
+		}
+
+		state, unhealthy := cr.state()
+		if unhealthy {
 			healthy = false
 		}
-		fmt.Fprintf(buf, "[%s] %s\n", state, cr.Check.Name)
+		notes := []string{}
+		if cr.Reason != "" {
+			notes = append(notes, cr.Reason)
+		}
+		if cr.Remediation.Attempts > 0 {
+			notes = append(notes, cr.Remediation.String(cr.Check.Remediate))
+		}
+
+		if len(notes) > 0 {
+			fmt.Fprintf(buf, "[%s] %s (%s): %s\n", state, cr.Check.Name, cr.Check.Source, strings.Join(notes, ", "))
+		} else {
+			fmt.Fprintf(buf, "[%s] %s (%s)\n", state, cr.Check.Name, cr.Check.Source)
+		}
 	}
 	checkResultsLock.RUnlock()
 
 	res.Header().Set("X-Collection-Parsed-In", strconv.FormatInt(time.Since(start).Nanoseconds()/int64(time.Microsecond), 10)+"ms")
 	res.Header().Set("X-Last-Result-Registered-At", lastResultRegistered.Format(time.RFC1123))
 	if healthy {
-		currentStatusCode.Set(http.StatusOK)
 		res.WriteHeader(http.StatusOK)
 	} else {
-		currentStatusCode.Set(http.StatusInternalServerError)
 		res.WriteHeader(http.StatusInternalServerError)
 	}
 
 	io.Copy(res, buf)
+
+	return healthy
 }
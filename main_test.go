@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestStatusRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/status/check/{id}", handleCheckHealthCheck)
+	r.HandleFunc("/status/tag/{tag}", handleTagHealthCheck)
+	return r
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{
+		"docker":   {Name: "Docker is running", Tags: []string{"docker", "runtime"}},
+		"disk":     {Name: "Disk has space", Tags: []string{"runtime"}},
+		"nginx":    {Name: "Nginx is running", Tags: []string{"web"}},
+		"untagged": {Name: "Untagged check"},
+	})
+	r := newTestStatusRouter()
+
+	checkResultsLock.Lock()
+	for id, success := range map[string]bool{"docker": true, "disk": true, "nginx": false, "untagged": false} {
+		cr := &checkResult{Check: checks[id], IsSuccess: success, Streak: 1}
+		if !success {
+			cr.Streak = cfg.UnhealthyThreshold
+		}
+		checkResults[id] = cr
+	}
+	checkResultsLock.Unlock()
+
+	for path, tc := range map[string]struct {
+		code     int
+		contains []string
+		excludes []string
+	}{
+		"/status/check/unknown": {code: http.StatusNotFound},
+		"/status/tag/unknown":   {code: http.StatusNotFound},
+		"/status/check/docker": {
+			code:     http.StatusOK,
+			contains: []string{"[PASS] Docker is running"},
+			excludes: []string{"Nginx", "Untagged"},
+		},
+		"/status/check/nginx": {
+			code:     http.StatusInternalServerError,
+			contains: []string{"[CRIT] Nginx is running"},
+			excludes: []string{"Docker"},
+		},
+		"/status/tag/runtime": {
+			code:     http.StatusOK,
+			contains: []string{"Docker is running", "Disk has space"},
+			excludes: []string{"Nginx", "Untagged"},
+		},
+		"/status/tag/web": {
+			code:     http.StatusInternalServerError,
+			contains: []string{"Nginx is running"},
+			excludes: []string{"Docker", "Untagged"},
+		},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != tc.code {
+			t.Errorf("Expected status %d for %s, got %d", tc.code, path, rec.Code)
+		}
+		for _, s := range tc.contains {
+			if !strings.Contains(rec.Body.String(), s) {
+				t.Errorf("Expected response of %s to contain %q: %s", path, s, rec.Body.String())
+			}
+		}
+		for _, s := range tc.excludes {
+			if strings.Contains(rec.Body.String(), s) {
+				t.Errorf("Expected response of %s not to contain %q: %s", path, s, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestTagStatusWithoutResults(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{
+		"docker": {Name: "Docker is running", Tags: []string{"docker"}},
+	})
+
+	// A defined tag without results yet is not unknown
+	rec := httptest.NewRecorder()
+	newTestStatusRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status/tag/docker", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("Unexpected response for tag without results: %d %q", rec.Code, rec.Body.String())
+	}
+}
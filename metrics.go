@@ -1,70 +1,371 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 )
 
 var (
+	checkDuration  *prometheus.HistogramVec
+	checkRuns      *prometheus.CounterVec
+	checkFailures  *prometheus.CounterVec
+	checkTimeouts  *prometheus.CounterVec
+	checkState     *prometheus.GaugeVec
+	checkStreak    *prometheus.GaugeVec
+	checkLastRun   *prometheus.GaugeVec
+	instanceHealth prometheus.Gauge
+
+	remediationRuns *prometheus.CounterVec
+	checkQueueDepth prometheus.Gauge
+	checkQueueWait  prometheus.Histogram
+
+	// Metrics with their old names and units, only registered when
+	// --metrics-legacy is set
 	checkPassing       *prometheus.GaugeVec
 	checkExecutionTime *prometheus.SummaryVec
 	currentStatusCode  prometheus.Gauge
 
+	// metricsRegistry contains all metrics exported on /metrics and
+	// pushed to the Pushgateway
+	metricsRegistry *prometheus.Registry
+
+	// metricsConstLabels contains the labels attached to all metrics
+	metricsConstLabels prometheus.Labels
+
 	dynamicLabels = []string{"check_id"}
+
+	// checkStates contains all states reported by the check_state series
+	checkStates = []string{"PASS", "WARN", "CRIT"}
 )
 
-func init() {
+// newMetricsRegistry creates a registry containing the metrics of the
+// checks and (if enabled) the Go runtime and process metrics
+func newMetricsRegistry() (*prometheus.Registry, error) {
+	reg := prometheus.NewRegistry()
+
+	if cfg.MetricsRuntime {
+		reg.MustRegister(prometheus.NewGoCollector())
+		reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	}
+
+	if err := initMetrics(reg); err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+// initMetrics creates the metrics of the checks and registers them in
+// the given registry
+func initMetrics(reg prometheus.Registerer) error {
+	if cfg.MetricsNamespace != "" && !model.IsValidMetricName(model.LabelValue(cfg.MetricsNamespace)) {
+		return fmt.Errorf("Invalid metrics namespace %q", cfg.MetricsNamespace)
+	}
+
 	hostname, err := os.Hostname()
 	if err != nil {
-		log.Fatalf("Unable to determine own hostname: %s", err)
+		return fmt.Errorf("Unable to determine own hostname: %s", err)
 	}
 
+	buckets, err := parseMetricsBuckets(cfg.MetricsBuckets)
+	if err != nil {
+		return err
+	}
+
+	labels, err := parseMetricsLabels(cfg.MetricsLabels)
+	if err != nil {
+		return err
+	}
+
+	metricsConstLabels = prometheus.Labels{"hostname": hostname}
+	for k, v := range labels {
+		metricsConstLabels[k] = v
+	}
+
+	r := &collectorRegistrar{reg: reg}
+
 	co := prometheus.GaugeOpts{
-		Subsystem:   "elb_instance_status",
-		ConstLabels: prometheus.Labels{"hostname": hostname},
+		Namespace:   cfg.MetricsNamespace,
+		ConstLabels: metricsConstLabels,
+	}
+
+	co.Name = "check_state"
+	co.Help = "Bit showing whether the check is in the state given by the state label (PASS, WARN, CRIT)"
+
+	checkState = r.register(prometheus.NewGaugeVec(co, []string{"check_id", "state"})).(*prometheus.GaugeVec)
+
+	co.Name = "check_streak"
+	co.Help = "Number of consecutive executions of the check having the same result"
+
+	checkStreak = r.register(prometheus.NewGaugeVec(co, dynamicLabels)).(*prometheus.GaugeVec)
+
+	co.Name = "check_last_run_timestamp_seconds"
+	co.Help = "Unix timestamp of the start of the last execution of the check"
+
+	checkLastRun = r.register(prometheus.NewGaugeVec(co, dynamicLabels)).(*prometheus.GaugeVec)
+
+	co.Name = "healthy"
+	co.Help = "Bit showing whether the instance is considered healthy (=1) or not (=0), updated on every check result"
+
+	instanceHealth = r.register(prometheus.NewGauge(co)).(prometheus.Gauge)
+	instanceHealth.Set(1)
+
+	co.Name = "check_queue_depth"
+	co.Help = "Number of checks waiting for a free execution slot"
+
+	checkQueueDepth = r.register(prometheus.NewGauge(co)).(prometheus.Gauge)
+
+	checkDuration = r.register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   co.Namespace,
+		Subsystem:   co.Subsystem,
+		ConstLabels: co.ConstLabels,
+		Name:        "check_duration_seconds",
+		Help:        "Time the execution of the check took",
+		Buckets:     buckets,
+	}, dynamicLabels)).(*prometheus.HistogramVec)
+
+	checkRuns = r.register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   co.Namespace,
+		Subsystem:   co.Subsystem,
+		ConstLabels: co.ConstLabels,
+		Name:        "check_runs_total",
+		Help:        "Number of executions of the check",
+	}, dynamicLabels)).(*prometheus.CounterVec)
+
+	checkFailures = r.register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   co.Namespace,
+		Subsystem:   co.Subsystem,
+		ConstLabels: co.ConstLabels,
+		Name:        "check_failures_total",
+		Help:        "Number of executions of the check which failed",
+	}, dynamicLabels)).(*prometheus.CounterVec)
+
+	checkTimeouts = r.register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   co.Namespace,
+		Subsystem:   co.Subsystem,
+		ConstLabels: co.ConstLabels,
+		Name:        "check_timeouts_total",
+		Help:        "Number of check executions which were terminated because they timed out",
+	}, dynamicLabels)).(*prometheus.CounterVec)
+
+	remediationRuns = r.register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   co.Namespace,
+		Subsystem:   co.Subsystem,
+		ConstLabels: co.ConstLabels,
+		Name:        "remediation_runs_total",
+		Help:        "Number of remediation attempts of failing checks by result (success, failure)",
+	}, []string{"check_id", "result"})).(*prometheus.CounterVec)
+
+	checkQueueWait = r.register(prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   co.Namespace,
+		Subsystem:   co.Subsystem,
+		ConstLabels: co.ConstLabels,
+		Name:        "check_queue_wait_seconds",
+		Help:        "Time checks waited for a free execution slot",
+		Buckets:     buckets,
+	})).(prometheus.Histogram)
+
+	checkPassing, checkExecutionTime, currentStatusCode = nil, nil, nil
+	if !cfg.MetricsLegacy {
+		return r.err
 	}
 
 	co.Name = "check_passing"
 	co.Help = "Bit showing whether the check PASSed (=1) or FAILed (=0), WARNs are also reported as FAILs"
 
-	cp := prometheus.NewGaugeVec(co, dynamicLabels)
+	checkPassing = r.register(prometheus.NewGaugeVec(co, dynamicLabels)).(*prometheus.GaugeVec)
 
 	co.Name = "status_code"
 	co.Help = "Contains the current HTTP status code the ELB is seeing"
 
-	csc := prometheus.NewGauge(co)
+	currentStatusCode = r.register(prometheus.NewGauge(co)).(prometheus.Gauge)
+	currentStatusCode.Set(http.StatusOK)
 
-	cet := prometheus.NewSummaryVec(prometheus.SummaryOpts{
+	checkExecutionTime = r.register(prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Namespace:   co.Namespace,
 		Subsystem:   co.Subsystem,
 		ConstLabels: co.ConstLabels,
 		Name:        "check_execution_time",
 		Help:        "Timespan in µs the execution of the check took",
-	}, dynamicLabels)
+	}, dynamicLabels)).(*prometheus.SummaryVec)
 
-	if err := prometheus.Register(cp); err != nil {
-		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
-			checkPassing = are.ExistingCollector.(*prometheus.GaugeVec)
-		} else {
-			panic(err)
+	return r.err
+}
+
+// parseMetricsBuckets converts the configured histogram buckets into
+// floats, using the Prometheus default buckets if none are given
+func parseMetricsBuckets(in []string) ([]float64, error) {
+	var buckets []float64
+	for _, b := range in {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(b, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metrics bucket %q: %s", b, err)
 		}
+		if len(buckets) > 0 && v <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("Metrics buckets must be sorted in increasing order")
+		}
+		buckets = append(buckets, v)
 	}
 
-	if err := prometheus.Register(csc); err != nil {
-		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
-			currentStatusCode = are.ExistingCollector.(prometheus.Gauge)
+	if len(buckets) == 0 {
+		return prometheus.DefBuckets, nil
+	}
+	return buckets, nil
+}
+
+// parseMetricsLabels converts the configured key=value pairs into
+// labels, values are rendered as templates to allow using the instance
+// metadata: az={{ metadata "placement/availability-zone" }}
+func parseMetricsLabels(in []string) (prometheus.Labels, error) {
+	labels := prometheus.Labels{}
+	for _, kv := range in {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Metrics label %q is not in format key=value", kv)
+		}
+
+		name := strings.TrimSpace(parts[0])
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) || isDynamicLabel(name) {
+			return nil, fmt.Errorf("Invalid metrics label name %q", name)
+		}
+
+		value, err := renderTemplateString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("Unable to render value of metrics label %q: %s", name, err)
+		}
+		labels[name] = value
+	}
+
+	return labels, nil
+}
+
+// isDynamicLabel reports whether the label is set by the metrics themselves
+// or by the Prometheus client for histograms and summaries
+func isDynamicLabel(name string) bool {
+	switch name {
+	case "check_id", "state", "result", "le", "quantile":
+		return true
+	}
+	return false
+}
+
+// recordCheckState updates the series describing the current state
+// of the check
+func recordCheckState(checkID string, cr checkResult) {
+	state, _ := cr.state()
+	for _, s := range checkStates {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		checkState.WithLabelValues(checkID, s).Set(v)
+	}
+
+	checkStreak.WithLabelValues(checkID).Set(float64(cr.Streak))
+	if !cr.LastRun.IsZero() {
+		checkLastRun.WithLabelValues(checkID).Set(float64(cr.LastRun.UnixNano()) / float64(time.Second))
+	}
+
+	if checkPassing != nil {
+		v := 0.0
+		if cr.IsSuccess {
+			v = 1
+		}
+		checkPassing.WithLabelValues(checkID).Set(v)
+	}
+}
+
+// recordCheckRun updates the series describing a single execution of
+// the check
+func recordCheckRun(checkID string, cr checkResult, duration time.Duration) {
+	checkRuns.WithLabelValues(checkID).Inc()
+	if !cr.IsSuccess {
+		checkFailures.WithLabelValues(checkID).Inc()
+	}
+	if cr.Reason == reasonTimeout {
+		checkTimeouts.WithLabelValues(checkID).Inc()
+	}
+	checkDuration.WithLabelValues(checkID).Observe(duration.Seconds())
+
+	if checkExecutionTime != nil {
+		checkExecutionTime.WithLabelValues(checkID).Observe(float64(duration.Nanoseconds()) / float64(time.Microsecond))
+	}
+
+	recordCheckState(checkID, cr)
+}
+
+// recordHealth updates the series describing the overall health
+func recordHealth(healthy bool) {
+	if healthy {
+		instanceHealth.Set(1)
+	} else {
+		instanceHealth.Set(0)
+	}
+
+	if currentStatusCode != nil {
+		if healthy {
+			currentStatusCode.Set(http.StatusOK)
 		} else {
-			panic(err)
+			currentStatusCode.Set(http.StatusInternalServerError)
 		}
 	}
+}
+
+// forgetCheckMetrics removes all series of a check which is no longer
+// defined
+func forgetCheckMetrics(checkID string) {
+	for _, v := range []*prometheus.MetricVec{
+		checkDuration.MetricVec, checkRuns.MetricVec, checkFailures.MetricVec,
+		checkTimeouts.MetricVec, checkStreak.MetricVec, checkLastRun.MetricVec,
+	} {
+		v.DeleteLabelValues(checkID)
+	}
+	for _, s := range checkStates {
+		checkState.DeleteLabelValues(checkID, s)
+	}
+	for _, r := range []string{"success", "failure"} {
+		remediationRuns.DeleteLabelValues(checkID, r)
+	}
 
-	if err := prometheus.Register(cet); err != nil {
+	if checkPassing != nil {
+		checkPassing.DeleteLabelValues(checkID)
+		checkExecutionTime.DeleteLabelValues(checkID)
+	}
+}
+
+// collectorRegistrar registers collectors and keeps the first error
+// to not need to check the error of every single registration
+type collectorRegistrar struct {
+	reg prometheus.Registerer
+	err error
+}
+
+// register registers the collector and returns it or the already
+// registered collector of the same kind
+func (r *collectorRegistrar) register(c prometheus.Collector) prometheus.Collector {
+	if err := r.reg.Register(c); err != nil {
 		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
-			checkExecutionTime = are.ExistingCollector.(*prometheus.SummaryVec)
-		} else {
-			panic(err)
+			return are.ExistingCollector
+		}
+		if r.err == nil {
+			r.err = fmt.Errorf("Unable to register metrics: %s", err)
 		}
 	}
+	return c
 }
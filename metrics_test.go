@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherMetric returns the metric with the given name and label values
+// or nil if there is no such metric in the registry
+func gatherMetric(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) *dto.Metric {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gathering metrics failed: %s", err)
+	}
+
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+
+	metrics:
+		for _, m := range mf.GetMetric() {
+			values := map[string]string{}
+			for _, lp := range m.GetLabel() {
+				values[lp.GetName()] = lp.GetValue()
+			}
+			for k, v := range labels {
+				if values[k] != v {
+					continue metrics
+				}
+			}
+			return m
+		}
+	}
+
+	return nil
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	cfg.MetricsNamespace = "test"
+	cfg.MetricsLabels = []string{"role=web"}
+	cfg.MetricsBuckets = []string{"0.5", "1"}
+	cfg.MetricsRuntime = false
+	cfg.MetricsLegacy = false
+
+	reg, err := newMetricsRegistry()
+	if err != nil {
+		t.Fatalf("Creating registry failed: %s", err)
+	}
+
+	recordCheckRun("docker", checkResult{
+		Check:   checkCommand{Name: "Docker is running"},
+		Streak:  2,
+		LastRun: time.Unix(1500000000, 0),
+		Reason:  reasonTimeout,
+	}, 750*time.Millisecond)
+
+	if m := gatherMetric(t, reg, "test_check_state", map[string]string{"check_id": "docker", "state": "CRIT", "role": "web"}); m == nil || m.GetGauge().GetValue() != 1 {
+		t.Errorf("Expected CRIT state to be set, got %v", m)
+	}
+	if m := gatherMetric(t, reg, "test_check_state", map[string]string{"check_id": "docker", "state": "PASS"}); m == nil || m.GetGauge().GetValue() != 0 {
+		t.Errorf("Expected PASS state to be unset, got %v", m)
+	}
+	if m := gatherMetric(t, reg, "test_check_streak", map[string]string{"check_id": "docker"}); m == nil || m.GetGauge().GetValue() != 2 {
+		t.Errorf("Expected streak of 2, got %v", m)
+	}
+	if m := gatherMetric(t, reg, "test_check_last_run_timestamp_seconds", map[string]string{"check_id": "docker"}); m == nil || m.GetGauge().GetValue() != 1500000000 {
+		t.Errorf("Expected last run timestamp, got %v", m)
+	}
+	if m := gatherMetric(t, reg, "test_check_failures_total", map[string]string{"check_id": "docker"}); m == nil || m.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected one failure, got %v", m)
+	}
+	if m := gatherMetric(t, reg, "test_check_timeouts_total", map[string]string{"check_id": "docker"}); m == nil || m.GetCounter().GetValue() != 1 {
+		t.Errorf("Expected one timeout, got %v", m)
+	}
+
+	m := gatherMetric(t, reg, "test_check_duration_seconds", map[string]string{"check_id": "docker"})
+	if m == nil {
+		t.Fatalf("Expected duration histogram")
+	}
+	if b := m.GetHistogram().GetBucket(); len(b) != 2 || b[0].GetCumulativeCount() != 0 || b[1].GetCumulativeCount() != 1 {
+		t.Errorf("Unexpected histogram buckets: %v", b)
+	}
+
+	if gatherMetric(t, reg, "go_goroutines", nil) != nil {
+		t.Errorf("Runtime metrics were registered though disabled")
+	}
+	if gatherMetric(t, reg, "test_check_passing", nil) != nil {
+		t.Errorf("Legacy metrics were registered though disabled")
+	}
+
+	forgetCheckMetrics("docker")
+	if m := gatherMetric(t, reg, "test_check_state", map[string]string{"check_id": "docker"}); m != nil {
+		t.Errorf("Metrics of removed check are still exported: %v", m)
+	}
+}
+
+func TestMetricsHealth(t *testing.T) {
+	cfg.MetricsNamespace = "test"
+	cfg.MetricsLabels = nil
+	cfg.MetricsBuckets = nil
+	cfg.MetricsRuntime = false
+	cfg.MetricsLegacy = true
+
+	reg, err := newMetricsRegistry()
+	if err != nil {
+		t.Fatalf("Creating registry failed: %s", err)
+	}
+
+	recordHealth(false)
+
+	if m := gatherMetric(t, reg, "test_healthy", nil); m == nil || m.GetGauge().GetValue() != 0 {
+		t.Errorf("Expected instance to be reported unhealthy, got %v", m)
+	}
+	if m := gatherMetric(t, reg, "test_status_code", nil); m == nil || m.GetGauge().GetValue() != 500 {
+		t.Errorf("Expected legacy status code 500, got %v", m)
+	}
+}
+
+func TestParseMetricsLabels(t *testing.T) {
+	labels, err := parseMetricsLabels([]string{"role=web", "team = ops=infra", ""})
+	if err != nil {
+		t.Fatalf("Parsing valid labels failed: %s", err)
+	}
+	if labels["role"] != "web" || labels["team"] != " ops=infra" || len(labels) != 2 {
+		t.Errorf("Unexpected labels: %v", labels)
+	}
+
+	for _, in := range []string{"role", "1role=web", "check_id=foo", "__name__=foo", "__role=web", "le=1", "role={{ broken"} {
+		if _, err := parseMetricsLabels([]string{in}); err == nil {
+			t.Errorf("Expected label %q to be rejected", in)
+		}
+	}
+}
+
+func TestInitMetricsErrors(t *testing.T) {
+	defer func() { cfg.MetricsNamespace, cfg.MetricsLabels = "test", nil }()
+
+	cfg.MetricsNamespace = "my-service"
+	if _, err := newMetricsRegistry(); err == nil {
+		t.Errorf("Invalid metrics namespace was accepted")
+	}
+
+	cfg.MetricsNamespace = "test"
+	cfg.MetricsLabels = []string{"__role=web"}
+	if _, err := newMetricsRegistry(); err == nil {
+		t.Errorf("Reserved metrics label was accepted")
+	}
+
+	// A metric of the same name but having a different help is no
+	// collector which can be reused
+	cfg.MetricsLabels = nil
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_healthy", Help: "Conflicting"}))
+	if err := initMetrics(reg); err == nil || !strings.Contains(err.Error(), "Unable to register metrics") {
+		t.Errorf("Expected registration error, got %v", err)
+	}
+}
+
+func TestParseMetricsBuckets(t *testing.T) {
+	buckets, err := parseMetricsBuckets([]string{"0.1", " 1", "10"})
+	if err != nil {
+		t.Fatalf("Parsing valid buckets failed: %s", err)
+	}
+	if len(buckets) != 3 || buckets[1] != 1 {
+		t.Errorf("Unexpected buckets: %v", buckets)
+	}
+
+	if buckets, _ := parseMetricsBuckets(nil); len(buckets) != len(prometheus.DefBuckets) {
+		t.Errorf("Expected default buckets, got %v", buckets)
+	}
+
+	for _, in := range [][]string{{"a"}, {"1", "0.5"}} {
+		if _, err := parseMetricsBuckets(in); err == nil {
+			t.Errorf("Expected buckets %v to be rejected", in)
+		}
+	}
+}
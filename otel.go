@@ -0,0 +1,717 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	otelMaxBatchSize      = 512
+	otelExportRetries     = 2
+	otelDefaultService    = "elb-instance-status"
+	otelTemporalityCumul  = 2
+	otelSpanKindInternal  = 1
+	otelSpanKindServer    = 2
+	otelStatusCodeOK      = 1
+	otelStatusCodeError   = 2
+	otelSupportedProtocol = "http/json"
+)
+
+// otelExporter sends spans and metrics to an OpenTelemetry collector using
+// OTLP/HTTP with JSON encoding, it is nil if no endpoint is configured
+var otelExporter *otlpExporter
+
+type otelKeyValue struct {
+	Key   string       `json:"key"`
+	Value otelAnyValue `json:"value"`
+}
+
+type otelAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func otelString(key, value string) otelKeyValue {
+	return otelKeyValue{Key: key, Value: otelAnyValue{StringValue: &value}}
+}
+
+func otelInt(key string, value int64) otelKeyValue {
+	v := strconv.FormatInt(value, 10)
+	return otelKeyValue{Key: key, Value: otelAnyValue{IntValue: &v}}
+}
+
+func otelBool(key string, value bool) otelKeyValue {
+	return otelKeyValue{Key: key, Value: otelAnyValue{BoolValue: &value}}
+}
+
+type otelResource struct {
+	Attributes []otelKeyValue `json:"attributes"`
+}
+
+type otelScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otelStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otelSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otelKeyValue `json:"attributes,omitempty"`
+	Status            otelStatus     `json:"status"`
+}
+
+type otelMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Gauge       *otelGauge     `json:"gauge,omitempty"`
+	Sum         *otelSum       `json:"sum,omitempty"`
+	Histogram   *otelHistogram `json:"histogram,omitempty"`
+	Summary     *otelSummary   `json:"summary,omitempty"`
+}
+
+type otelGauge struct {
+	DataPoints []otelNumberPoint `json:"dataPoints"`
+}
+
+type otelSum struct {
+	DataPoints             []otelNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otelNumberPoint struct {
+	Attributes        []otelKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otelHistogram struct {
+	DataPoints             []otelHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otelHistogramPoint struct {
+	Attributes        []otelKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	Count             string         `json:"count"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+type otelSummary struct {
+	DataPoints []otelSummaryPoint `json:"dataPoints"`
+}
+
+type otelSummaryPoint struct {
+	Attributes        []otelKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	Count             string         `json:"count"`
+	Sum               float64        `json:"sum"`
+	QuantileValues    []otelQuantile `json:"quantileValues,omitempty"`
+}
+
+type otelQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpExporter struct {
+	tracesURL  string
+	metricsURL string
+	headers    map[string]string
+	timeout    time.Duration
+
+	scheduleDelay  time.Duration
+	metricInterval time.Duration
+
+	resource otelResource
+	scope    otelScope
+	start    time.Time
+
+	spans    chan otelSpan
+	flushReq chan chan struct{}
+}
+
+// initOTel configures the OpenTelemetry export from the standard OTEL_*
+// environment variables and starts exporting if an endpoint is set
+func initOTel() error {
+	e, err := newOTLPExporterFromEnv()
+	if err != nil || e == nil {
+		return err
+	}
+
+	otelExporter = e
+	go e.run()
+	if e.metricsURL != "" {
+		go e.exportMetricsPeriodically()
+	}
+
+	return nil
+}
+
+func newOTLPExporterFromEnv() (*otlpExporter, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+
+	e := &otlpExporter{
+		tracesURL:  otelSignalEndpoint("TRACES", "v1/traces"),
+		metricsURL: otelSignalEndpoint("METRICS", "v1/metrics"),
+		scope:      otelScope{Name: otelDefaultService, Version: version},
+		start:      time.Now(),
+	}
+
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		e.tracesURL = ""
+	}
+	if os.Getenv("OTEL_METRICS_EXPORTER") == "none" {
+		e.metricsURL = ""
+	}
+	if e.tracesURL == "" && e.metricsURL == "" {
+		return nil, nil
+	}
+
+	// Sending a different encoding than configured would only fail on
+	// every export, so unsupported protocols are rejected on start
+	if p := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); p != "" && p != otelSupportedProtocol {
+		return nil, fmt.Errorf("Unsupported OTLP protocol %q, only %s is supported", p, otelSupportedProtocol)
+	}
+
+	var err error
+	if e.headers, err = parseOTelKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")); err != nil {
+		return nil, fmt.Errorf("Invalid OTEL_EXPORTER_OTLP_HEADERS: %s", err)
+	}
+	if e.timeout, err = otelEnvMillis("OTEL_EXPORTER_OTLP_TIMEOUT", 10000); err != nil {
+		return nil, err
+	}
+	if e.scheduleDelay, err = otelEnvMillis("OTEL_BSP_SCHEDULE_DELAY", 5000); err != nil {
+		return nil, err
+	}
+	if e.metricInterval, err = otelEnvMillis("OTEL_METRIC_EXPORT_INTERVAL", 60000); err != nil {
+		return nil, err
+	}
+
+	queueSize := 2048
+	if v := os.Getenv("OTEL_BSP_MAX_QUEUE_SIZE"); v != "" {
+		if queueSize, err = strconv.Atoi(v); err != nil || queueSize < 1 {
+			return nil, fmt.Errorf("Invalid OTEL_BSP_MAX_QUEUE_SIZE %q", v)
+		}
+	}
+	e.spans = make(chan otelSpan, queueSize)
+	e.flushReq = make(chan chan struct{})
+
+	if e.resource, err = otelResourceFromEnv(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// otelSignalEndpoint returns the signal specific endpoint or the path of
+// the signal below the generic endpoint
+func otelSignalEndpoint(signal, path string) string {
+	if u := os.Getenv("OTEL_EXPORTER_OTLP_" + signal + "_ENDPOINT"); u != "" {
+		return u
+	}
+	if u := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); u != "" {
+		return strings.TrimRight(u, "/") + "/" + path
+	}
+	return ""
+}
+
+func otelEnvMillis(name string, def int) (time.Duration, error) {
+	ms := def
+	if v := os.Getenv(name); v != "" {
+		var err error
+		if ms, err = strconv.Atoi(v); err != nil || ms <= 0 {
+			return 0, fmt.Errorf("Invalid %s %q", name, v)
+		}
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// parseOTelKeyValues parses the key1=value1,key2=value2 format used by
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_RESOURCE_ATTRIBUTES
+func parseOTelKeyValues(in string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, kv := range strings.Split(in, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%q is not in format key=value", kv)
+		}
+
+		value, err := url.PathUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		out[strings.TrimSpace(parts[0])] = value
+	}
+	return out, nil
+}
+
+func otelResourceFromEnv() (otelResource, error) {
+	attrs, err := parseOTelKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		return otelResource{}, fmt.Errorf("Invalid OTEL_RESOURCE_ATTRIBUTES: %s", err)
+	}
+
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		attrs["service.name"] = name
+	}
+	if attrs["service.name"] == "" {
+		attrs["service.name"] = otelDefaultService
+	}
+	if _, ok := attrs["service.version"]; !ok {
+		attrs["service.version"] = version
+	}
+	if _, ok := attrs["host.name"]; !ok {
+		if hostname, err := os.Hostname(); err == nil {
+			attrs["host.name"] = hostname
+		}
+	}
+
+	keys := []string{}
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	res := otelResource{}
+	for _, k := range keys {
+		res.Attributes = append(res.Attributes, otelString(k, attrs[k]))
+	}
+	return res, nil
+}
+
+// run batches the recorded spans and exports them when the batch is full
+// or the schedule delay passed
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(e.scheduleDelay)
+	defer ticker.Stop()
+
+	batch := []otelSpan{}
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) < otelMaxBatchSize {
+				continue
+			}
+
+		case <-ticker.C:
+
+		case done := <-e.flushReq:
+			for pending := true; pending; {
+				select {
+				case s := <-e.spans:
+					batch = append(batch, s)
+				default:
+					pending = false
+				}
+			}
+			e.exportSpans(batch)
+			batch = []otelSpan{}
+			close(done)
+			continue
+		}
+
+		e.exportSpans(batch)
+		batch = []otelSpan{}
+	}
+}
+
+func (e *otlpExporter) addSpan(s otelSpan) {
+	if e == nil || e.tracesURL == "" {
+		return
+	}
+
+	select {
+	case e.spans <- s:
+	default:
+		// Spans are dropped when the collector can not keep up instead
+		// of delaying the checks
+	}
+}
+
+func (e *otlpExporter) exportSpans(spans []otelSpan) {
+	if len(spans) == 0 {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": e.resource,
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": e.scope,
+				"spans": spans,
+			}},
+		}},
+	}
+
+	if err := e.post(e.tracesURL, payload); err != nil {
+		logger.WithFields(logFields{"error": err, "spans": len(spans)}).Warnf("Unable to export spans")
+	}
+}
+
+func (e *otlpExporter) exportMetricsPeriodically() {
+	for range time.Tick(e.metricInterval) {
+		e.exportMetrics()
+	}
+}
+
+// exportMetrics sends the current values of all metrics in the metrics
+// registry to the collector
+func (e *otlpExporter) exportMetrics() {
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		logger.WithFields(logFields{"error": err}).Warnf("Unable to gather metrics for export")
+		return
+	}
+
+	metrics := otelMetricsFromFamilies(families, e.start, time.Now())
+	if len(metrics) == 0 {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": e.resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   e.scope,
+				"metrics": metrics,
+			}},
+		}},
+	}
+
+	if err := e.post(e.metricsURL, payload); err != nil {
+		logger.WithFields(logFields{"error": err}).Warnf("Unable to export metrics")
+	}
+}
+
+// shutdown exports all pending spans and the current metrics
+func (e *otlpExporter) shutdown() {
+	if e == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	e.flushReq <- done
+	<-done
+
+	if e.metricsURL != "" {
+		e.exportMetrics()
+	}
+}
+
+func (e *otlpExporter) post(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: e.timeout}
+	for attempt := 0; attempt <= otelExportRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt - 1))
+		}
+
+		var req *http.Request
+		if req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(body)); err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range e.headers {
+			req.Header.Set(k, v)
+		}
+
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return nil
+		}
+
+		err = fetchError{Resource: url, StatusCode: resp.StatusCode}
+		if !retryable(err) {
+			break
+		}
+	}
+
+	return err
+}
+
+// otelMetricsFromFamilies converts the gathered Prometheus metrics into
+// their OTLP representation using cumulative temporality
+func otelMetricsFromFamilies(families []*dto.MetricFamily, start, now time.Time) []otelMetric {
+	startNano := otelTime(start)
+	nowNano := otelTime(now)
+
+	out := []otelMetric{}
+	for _, mf := range families {
+		m := otelMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		if strings.HasSuffix(m.Name, "_seconds") {
+			m.Unit = "s"
+		}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otelSum{AggregationTemporality: otelTemporalityCumul, IsMonotonic: true}
+			for _, pm := range mf.GetMetric() {
+				if v := pm.GetCounter().GetValue(); isFinite(v) {
+					m.Sum.DataPoints = append(m.Sum.DataPoints, otelNumberPoint{
+						Attributes: otelLabels(pm), StartTimeUnixNano: startNano, TimeUnixNano: nowNano, AsDouble: v,
+					})
+				}
+			}
+
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &otelGauge{}
+			for _, pm := range mf.GetMetric() {
+				v := pm.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = pm.GetUntyped().GetValue()
+				}
+				if isFinite(v) {
+					m.Gauge.DataPoints = append(m.Gauge.DataPoints, otelNumberPoint{
+						Attributes: otelLabels(pm), TimeUnixNano: nowNano, AsDouble: v,
+					})
+				}
+			}
+
+		case dto.MetricType_HISTOGRAM:
+			m.Histogram = &otelHistogram{AggregationTemporality: otelTemporalityCumul}
+			for _, pm := range mf.GetMetric() {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, otelHistogramFromMetric(pm, startNano, nowNano))
+			}
+
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otelSummary{}
+			for _, pm := range mf.GetMetric() {
+				s := pm.GetSummary()
+				p := otelSummaryPoint{
+					Attributes:        otelLabels(pm),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:               s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					// Quantiles without observations are NaN which can
+					// not be encoded in JSON
+					if isFinite(q.GetValue()) {
+						p.QuantileValues = append(p.QuantileValues, otelQuantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+					}
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+			}
+
+		default:
+			continue
+		}
+
+		out = append(out, m)
+	}
+
+	return out
+}
+
+// otelHistogramFromMetric converts the cumulative Prometheus buckets into
+// the per-bucket counts used by OTLP
+func otelHistogramFromMetric(pm *dto.Metric, startNano, nowNano string) otelHistogramPoint {
+	h := pm.GetHistogram()
+	p := otelHistogramPoint{
+		Attributes:        otelLabels(pm),
+		StartTimeUnixNano: startNano,
+		TimeUnixNano:      nowNano,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+		BucketCounts:      []string{},
+		ExplicitBounds:    []float64{},
+	}
+
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			continue
+		}
+		p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+		p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+		prev = b.GetCumulativeCount()
+	}
+	p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+
+	return p
+}
+
+func otelLabels(pm *dto.Metric) []otelKeyValue {
+	attrs := []otelKeyValue{}
+	for _, lp := range pm.GetLabel() {
+		attrs = append(attrs, otelString(lp.GetName(), lp.GetValue()))
+	}
+	return attrs
+}
+
+func otelTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func otelRandomID(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// recordCheckSpan records the execution of a check as a span
+func recordCheckSpan(checkID string, check checkCommand, start, end time.Time, reason string, err error) {
+	if otelExporter == nil {
+		return
+	}
+
+	s := otelSpan{
+		TraceID:           otelRandomID(16),
+		SpanID:            otelRandomID(8),
+		Name:              "execute check",
+		Kind:              otelSpanKindInternal,
+		StartTimeUnixNano: otelTime(start),
+		EndTimeUnixNano:   otelTime(end),
+		Attributes: []otelKeyValue{
+			otelString("check.id", checkID),
+			otelString("check.name", check.Name),
+			otelString("check.command_hash", check.Command.hash()),
+			otelInt("process.exit_code", int64(exitCode(err))),
+			otelBool("check.timed_out", reason == reasonTimeout),
+		},
+		Status: otelStatus{Code: otelStatusCodeOK},
+	}
+
+	if reason != "" {
+		s.Attributes = append(s.Attributes, otelString("check.reason", reason))
+	}
+	if err != nil {
+		s.Status = otelStatus{Code: otelStatusCodeError, Message: err.Error()}
+	}
+
+	otelExporter.addSpan(s)
+}
+
+// exitCode returns the exit code of the check command or -1 if it did
+// not exit normally
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
+// hash returns a short hash of the command to correlate executions with
+// the definition of the check without exporting the command itself
+func (c commandLine) hash() string {
+	h := sha256.New()
+	if c.Script != "" {
+		h.Write([]byte(c.Script))
+	} else {
+		h.Write([]byte(strings.Join(c.Args, "\x00")))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// traceRequest records a span for each request to the handler,
+// continuing the trace given in the W3C traceparent header
+func traceRequest(route string, h http.HandlerFunc) http.HandlerFunc {
+	if otelExporter == nil {
+		return h
+	}
+
+	return func(res http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+
+		h(rec, r)
+
+		s := otelSpan{
+			SpanID:            otelRandomID(8),
+			Name:              r.Method + " " + route,
+			Kind:              otelSpanKindServer,
+			StartTimeUnixNano: otelTime(start),
+			EndTimeUnixNano:   otelTime(time.Now()),
+			Attributes: []otelKeyValue{
+				otelString("http.method", r.Method),
+				otelString("http.route", route),
+				otelString("http.target", r.URL.RequestURI()),
+				otelInt("http.status_code", int64(rec.status)),
+			},
+		}
+		s.TraceID, s.ParentSpanID = parseTraceparent(r.Header.Get("traceparent"))
+		if s.TraceID == "" {
+			s.TraceID = otelRandomID(16)
+		}
+
+		otelExporter.addSpan(s)
+	}
+}
+
+// parseTraceparent extracts trace and parent span ID from a W3C
+// traceparent header, returning empty strings for invalid headers
+func parseTraceparent(header string) (string, string) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+
+	for _, p := range parts[1:3] {
+		if _, err := hex.DecodeString(p); err != nil || strings.Trim(p, "0") == "" {
+			return "", ""
+		}
+	}
+
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2])
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOTLPExport(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		payloads = map[string][]byte{}
+		headers  = map[string]string{}
+	)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		lock.Lock()
+		defer lock.Unlock()
+		payloads[r.URL.Path] = body
+		headers[r.URL.Path] = r.Header.Get("X-Api-Key")
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "X-Api-Key=secret%20key")
+	t.Setenv("OTEL_SERVICE_NAME", "status-test")
+
+	cfg.MetricsNamespace = "test"
+	cfg.MetricsLabels = nil
+	cfg.MetricsBuckets = nil
+	cfg.MetricsRuntime = false
+	cfg.MetricsLegacy = false
+
+	var err error
+	if metricsRegistry, err = newMetricsRegistry(); err != nil {
+		t.Fatalf("Creating registry failed: %s", err)
+	}
+
+	if err := initOTel(); err != nil {
+		t.Fatalf("Initializing export failed: %s", err)
+	}
+	defer func() { otelExporter = nil }()
+
+	start := time.Now()
+	recordCheckSpan("docker", checkCommand{Name: "Docker is running", Command: commandLine{Script: "docker ps"}},
+		start, start.Add(time.Second), reasonTimeout, errors.New(reasonTimeout))
+	recordCheckRun("docker", checkResult{IsSuccess: true, Streak: 1, LastRun: start}, time.Second)
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	traceRequest("/status", func(res http.ResponseWriter, r *http.Request) {
+		res.WriteHeader(http.StatusInternalServerError)
+	})(httptest.NewRecorder(), req)
+
+	otelExporter.shutdown()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if headers["/v1/traces"] != "secret key" {
+		t.Errorf("Configured header was not sent, got %q", headers["/v1/traces"])
+	}
+
+	var traces struct {
+		ResourceSpans []struct {
+			Resource   otelResource `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otelSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(payloads["/v1/traces"], &traces); err != nil {
+		t.Fatalf("Traces payload is no valid JSON: %s", err)
+	}
+	if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected traces payload: %s", payloads["/v1/traces"])
+	}
+
+	if !hasOTelAttribute(traces.ResourceSpans[0].Resource.Attributes, "service.name", "status-test") {
+		t.Errorf("Service name is missing in resource: %+v", traces.ResourceSpans[0].Resource)
+	}
+
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+
+	if !hasOTelAttribute(spans[0].Attributes, "check.id", "docker") || spans[0].Status.Code != otelStatusCodeError {
+		t.Errorf("Unexpected check span: %+v", spans[0])
+	}
+	for _, a := range spans[0].Attributes {
+		if a.Key == "check.timed_out" && (a.Value.BoolValue == nil || !*a.Value.BoolValue) {
+			t.Errorf("Check span is not marked as timed out")
+		}
+	}
+
+	if spans[1].TraceID != "0af7651916cd43dd8448eb211c80319c" || spans[1].ParentSpanID != "b7ad6b7169203331" {
+		t.Errorf("Request span did not continue the trace: %+v", spans[1])
+	}
+
+	if !strings.Contains(string(payloads["/v1/metrics"]), `"name":"test_check_runs_total"`) {
+		t.Errorf("Metrics payload does not contain the check metrics: %s", payloads["/v1/metrics"])
+	}
+}
+
+func TestOTelHistogramConversion(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration_seconds", Help: "Test", Buckets: []float64{1, 5}})
+	reg.MustRegister(h)
+
+	for _, v := range []float64{0.5, 0.7, 3, 10} {
+		h.Observe(v)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gathering metrics failed: %s", err)
+	}
+
+	metrics := otelMetricsFromFamilies(families, time.Now(), time.Now())
+	if len(metrics) != 1 || metrics[0].Histogram == nil || metrics[0].Unit != "s" {
+		t.Fatalf("Unexpected conversion result: %+v", metrics)
+	}
+
+	p := metrics[0].Histogram.DataPoints[0]
+	if strings.Join(p.BucketCounts, ",") != "2,1,1" || p.Count != "4" {
+		t.Errorf("Unexpected bucket counts %v (count %s)", p.BucketCounts, p.Count)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for header, expect := range map[string]string{
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01": "0af7651916cd43dd8448eb211c80319c",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01": "",
+		"00-0af7651916cd43dd8448eb211c80319c-xyz-01":              "",
+		"": "",
+	} {
+		if traceID, _ := parseTraceparent(header); traceID != expect {
+			t.Errorf("Expected trace ID %q for header %q, got %q", expect, header, traceID)
+		}
+	}
+}
+
+func hasOTelAttribute(attrs []otelKeyValue, key, value string) bool {
+	for _, a := range attrs {
+		if a.Key == key && a.Value.StringValue != nil && *a.Value.StringValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOTLPProtocol(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+
+	for protocol, valid := range map[string]bool{
+		"":              true,
+		"http/json":     true,
+		"http/protobuf": false,
+		"grpc":          false,
+	} {
+		t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", protocol)
+
+		e, err := newOTLPExporterFromEnv()
+		if valid && (err != nil || e == nil) {
+			t.Errorf("Protocol %q was rejected: %v", protocol, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("Protocol %q was accepted", protocol)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "sync"
+
+const maxCapturedOutput = 4096
+
+// outputTail is a writer keeping only the last max bytes written to it
+type outputTail struct {
+	max int
+
+	buffer     []byte
+	bufferLock sync.Mutex
+}
+
+func newOutputTail(max int) *outputTail {
+	return &outputTail{
+		max:    max,
+		buffer: []byte{},
+	}
+}
+
+func (o *outputTail) Write(in []byte) (n int, err error) {
+	o.bufferLock.Lock()
+	defer o.bufferLock.Unlock()
+
+	o.buffer = append(o.buffer, in...)
+	if len(o.buffer) > o.max {
+		o.buffer = o.buffer[len(o.buffer)-o.max:]
+	}
+
+	return len(in), nil
+}
+
+func (o *outputTail) String() string {
+	o.bufferLock.Lock()
+	defer o.bufferLock.Unlock()
+
+	return string(o.buffer)
+}
@@ -4,23 +4,76 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"os"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+const truncationMarker = " [truncated]"
+
 type prefixedLogger struct {
 	channel       string
 	wrappedWriter io.Writer
 
+	// writeLine is called for every complete line written to the logger
+	writeLine func(line string)
+
+	// maxLineLength limits the length of a single line, longer lines
+	// are truncated (0 = unlimited)
+	maxLineLength int
+	// limiter limits the number of lines written (nil = unlimited)
+	limiter    *lineRateLimiter
+	suppressed int
+	truncating bool
+
 	buffer     []byte
 	bufferLock sync.Mutex
 }
 
 func newPrefixedLogger(wrappedWriter io.Writer, channel string) *prefixedLogger {
-	return &prefixedLogger{
+	p := &prefixedLogger{
 		channel:       channel,
 		wrappedWriter: wrappedWriter,
 		buffer:        []byte{},
 	}
+	p.writeLine = func(line string) {
+		fmt.Fprintf(p.wrappedWriter, "[%s] %s\n", p.channel, line)
+	}
+	return p
+}
+
+// newCheckOutputLogger creates a logger for the given output stream of a
+// check or returns nil if the stream should not be forwarded. Lines are
+// sent to the sink configured for the stream or to the daemon log, using
+// a structured log format every line is logged as an entry having the
+// fields check_id and stream. The limiter may be shared by the streams
+// of a check to limit the output of the check as a whole.
+func newCheckOutputLogger(checkID, stream string, limiter *lineRateLimiter) *prefixedLogger {
+	if checkLogDisabled[stream] {
+		return nil
+	}
+
+	p := newPrefixedLogger(os.Stderr, checkID+":"+stream)
+	p.maxLineLength = cfg.CheckLogMaxLine
+	p.limiter = limiter
+
+	if logger.format != "text" {
+		l := logger.WithFields(logFields{"check_id": checkID, "stream": stream})
+		p.writeLine = func(line string) { l.Infof("%s", line) }
+	}
+
+	if sink, ok := checkLogSinks[stream]; ok {
+		// Keep the output in the daemon log if the sink is unavailable
+		fallback := p.writeLine
+		p.writeLine = func(line string) {
+			if err := sink.writeLine(checkID, stream, line); err != nil {
+				fallback(line)
+			}
+		}
+	}
+
+	return p
 }
 
 func (p *prefixedLogger) dropCR(data []byte) []byte {
@@ -38,14 +91,133 @@ func (p *prefixedLogger) Write(in []byte) (n int, err error) {
 	p.buffer = append(p.buffer, in...)
 
 	for {
-		if i := bytes.IndexByte(p.buffer, '\n'); i >= 0 {
-			// We have a full newline-terminated line.
-			fmt.Fprintf(p.wrappedWriter, "[%s] %s\n", p.channel, string(p.dropCR(p.buffer[0:i])))
-			p.buffer = p.buffer[i+1 : len(p.buffer)]
-		} else {
+		i := bytes.IndexByte(p.buffer, '\n')
+		if i < 0 {
+			switch {
+			case p.truncating:
+				// Still inside a truncated line, discard the rest of it
+				p.buffer = p.buffer[:0]
+			case p.maxLineLength > 0 && len(p.buffer) > p.maxLineLength:
+				// Do not buffer lines without newline indefinitely
+				p.emit(p.truncate(p.buffer))
+				p.buffer = p.buffer[:0]
+				p.truncating = true
+			}
 			break
 		}
+
+		// We have a full newline-terminated line.
+		line := p.dropCR(p.buffer[0:i])
+		p.buffer = p.buffer[i+1:]
+
+		if p.truncating {
+			p.truncating = false
+			continue
+		}
+
+		if p.maxLineLength > 0 && len(line) > p.maxLineLength {
+			p.emit(p.truncate(line))
+		} else {
+			p.emit(string(line))
+		}
 	}
 
 	return
 }
+
+// Flush writes the remaining output not terminated by a newline and the
+// number of suppressed lines, it is called after the process exited
+func (p *prefixedLogger) Flush() {
+	p.bufferLock.Lock()
+	defer p.bufferLock.Unlock()
+
+	if len(p.buffer) > 0 && !p.truncating {
+		p.emit(string(p.dropCR(p.buffer)))
+	}
+	p.buffer = p.buffer[:0]
+	p.truncating = false
+
+	p.writeSuppressed()
+}
+
+// truncate shortens the line to the maximum line length without
+// splitting a multi-byte character and appends the truncation marker
+func (p *prefixedLogger) truncate(line []byte) string {
+	n := p.maxLineLength
+	for n > 0 && !utf8.RuneStart(line[n]) {
+		n--
+	}
+	return string(line[:n]) + truncationMarker
+}
+
+func (p *prefixedLogger) emit(line string) {
+	if !p.limiter.allow() {
+		p.suppressed++
+		return
+	}
+
+	p.writeSuppressed()
+	p.writeLine(line)
+}
+
+func (p *prefixedLogger) writeSuppressed() {
+	if p.suppressed == 0 {
+		return
+	}
+
+	p.writeLine(fmt.Sprintf("[%d lines suppressed]", p.suppressed))
+	p.suppressed = 0
+}
+
+// lineRateLimiter is a token bucket limiting the number of lines per
+// second with the given burst
+type lineRateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+
+	lock sync.Mutex
+}
+
+// newLineRateLimiter creates a limiter or returns nil (no limit) if the
+// rate is not positive
+func newLineRateLimiter(perSecond, burst int) *lineRateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &lineRateLimiter{
+		rate:   float64(perSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+func (l *lineRateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPrefixedLogger(t *testing.T) {
@@ -32,3 +34,95 @@ func TestPrefixedLogger(t *testing.T) {
 		t.Fatalf("Buffer contains %d characters, should contain 120", n)
 	}
 }
+
+// collectLines returns a logger appending all lines written to it to the
+// returned slice
+func collectLines() (*prefixedLogger, *[]string) {
+	lines := []string{}
+	pl := newPrefixedLogger(nil, "baum")
+	pl.writeLine = func(line string) { lines = append(lines, line) }
+	return pl, &lines
+}
+
+func TestPrefixedLoggerTruncation(t *testing.T) {
+	pl, lines := collectLines()
+	pl.maxLineLength = 10
+
+	pl.Write([]byte("short\n0123456789abcdef\n"))
+	pl.Write([]byte("0123456789"))
+	pl.Write([]byte("without newline"))
+	pl.Write([]byte(" continued\nnext\n"))
+
+	expect := []string{"short", "0123456789" + truncationMarker, "0123456789" + truncationMarker, "next"}
+	if strings.Join(*lines, "|") != strings.Join(expect, "|") {
+		t.Fatalf("Unexpected lines: %q", *lines)
+	}
+
+	if len(pl.buffer) != 0 {
+		t.Errorf("Buffer still contains %d bytes", len(pl.buffer))
+	}
+}
+
+func TestPrefixedLoggerTruncationUTF8(t *testing.T) {
+	pl, lines := collectLines()
+	pl.maxLineLength = 4
+
+	// "ü" takes two bytes and must not be split
+	pl.Write([]byte("abcüdef\n"))
+
+	if len(*lines) != 1 || (*lines)[0] != "abc"+truncationMarker {
+		t.Errorf("Unexpected lines: %q", *lines)
+	}
+}
+
+func TestPrefixedLoggerFlush(t *testing.T) {
+	pl, lines := collectLines()
+
+	pl.Write([]byte("complete\npartial\r"))
+	if len(*lines) != 1 {
+		t.Fatalf("Expected 1 line before flush, got %q", *lines)
+	}
+
+	pl.Flush()
+	if len(*lines) != 2 || (*lines)[1] != "partial" {
+		t.Fatalf("Partial line was not flushed: %q", *lines)
+	}
+
+	pl.Flush()
+	if len(*lines) != 2 {
+		t.Errorf("Second flush wrote lines: %q", *lines)
+	}
+}
+
+func TestPrefixedLoggerRateLimit(t *testing.T) {
+	now := time.Now()
+	limiter := newLineRateLimiter(1, 2)
+	limiter.now = func() time.Time { return now }
+	limiter.last = now
+
+	pl, lines := collectLines()
+	pl.limiter = limiter
+
+	pl.Write([]byte("1\n2\n3\n4\n5\n"))
+	if strings.Join(*lines, "|") != "1|2" {
+		t.Fatalf("Unexpected lines within burst: %q", *lines)
+	}
+
+	now = now.Add(time.Second)
+	pl.Write([]byte("6\n"))
+	if strings.Join(*lines, "|") != "1|2|[3 lines suppressed]|6" {
+		t.Fatalf("Suppressed lines were not summarized: %q", *lines)
+	}
+
+	pl.Write([]byte("7\n"))
+	pl.Flush()
+	if (*lines)[len(*lines)-1] != "[1 lines suppressed]" {
+		t.Errorf("Suppressed lines were not summarized on flush: %q", *lines)
+	}
+}
+
+func TestLineRateLimiterDisabled(t *testing.T) {
+	if l := newLineRateLimiter(0, 10); l != nil || !l.allow() {
+		t.Errorf("Limiter without rate should allow all lines")
+	}
+}
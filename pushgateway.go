@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const pushTimeout = 10 * time.Second
+
+// metricsPusher periodically pushes the metrics to the Pushgateway until
+// it is stopped
+type metricsPusher struct {
+	pusher *push.Pusher
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// newMetricsPusher creates a pusher for the group of this instance, grouped
+// by hostname and (if available) instance ID
+func newMetricsPusher() *metricsPusher {
+	// The hostname label is already attached to all metrics and the
+	// Pushgateway rejects grouping labels contained in the metrics, so
+	// the hostname is used as the instance label instead
+	pusher := push.New(cfg.PushgatewayURL, cfg.PushJob).
+		Gatherer(metricsRegistry).
+		Client(&http.Client{Timeout: pushTimeout}).
+		Grouping("instance", metricsConstLabels["hostname"])
+
+	if instanceID, err := metadataClient.get("meta-data/instance-id"); err == nil {
+		pusher = pusher.Grouping("instance_id", instanceID)
+	}
+
+	return &metricsPusher{
+		pusher: pusher,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// run pushes the metrics every push interval until stopped. Push uses PUT
+// which replaces all metrics of the group so metrics of removed checks do
+// not stay in the Pushgateway forever.
+func (m *metricsPusher) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(cfg.PushInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.pusher.Push(); err != nil {
+			logger.WithFields(logFields{"error": err}).Warnf("Unable to push metrics")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// shutdown stops pushing and removes the group of this instance from the
+// Pushgateway to not keep reporting an instance which is gone. Pushing is
+// stopped first as a push after the deletion would recreate the group.
+func (m *metricsPusher) shutdown() {
+	close(m.stop)
+	<-m.done
+
+	if err := m.pusher.Delete(); err != nil {
+		logger.WithFields(logFields{"error": err}).Errorf("Unable to delete metrics from Pushgateway")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsPusher(t *testing.T) {
+	metricsRegistry = setupTestChecks(t, map[string]checkCommand{})
+	defer func() { metricsRegistry = nil }()
+	defer withTestMetadata(map[string]string{"instance-id": "i-0123456789"})()
+
+	var (
+		lock     sync.Mutex
+		requests []string
+	)
+
+	pgw := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+
+		if r.Method == http.MethodPut && !strings.Contains(string(body), "test_healthy") {
+			t.Errorf("Pushed metrics do not contain the health: %s", body)
+		}
+		res.WriteHeader(http.StatusAccepted)
+	}))
+	defer pgw.Close()
+
+	cfg.PushgatewayURL = pgw.URL
+	cfg.PushJob = "elb-instance-status"
+	cfg.PushInterval = 20 * time.Millisecond
+	defer func() { cfg.PushgatewayURL = "" }()
+
+	pusher := newMetricsPusher()
+	go pusher.run()
+	time.Sleep(100 * time.Millisecond)
+	pusher.shutdown()
+
+	// Give a push still running after the deletion the chance to arrive
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(requests) < 2 {
+		t.Fatalf("Expected metrics to be pushed periodically, got %v", requests)
+	}
+
+	for i, req := range requests {
+		method := http.MethodPut
+		if i == len(requests)-1 {
+			method = http.MethodDelete
+		}
+
+		// The order of the grouping labels in the path is not stable
+		if !strings.HasPrefix(req, method+" /metrics/job/elb-instance-status/") ||
+			!strings.Contains(req, "/instance/"+metricsConstLabels["hostname"]) ||
+			!strings.Contains(req, "/instance_id/i-0123456789") {
+			t.Errorf("Unexpected request %d: %s, expected %s to the group of the instance", i+1, req, method)
+		}
+	}
+}
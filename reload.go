@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// reloadLock ensures only one reload is processed at a time as reloads
+// can be triggered by the refresh cron, signals, file changes and the API
+var reloadLock sync.Mutex
+
+type checksDiff struct {
+	Added, Removed, Changed []string
+}
+
+func (d checksDiff) isEmpty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed) == 0
+}
+
+func (d checksDiff) String() string {
+	if d.isEmpty() {
+		return "no changes"
+	}
+
+	parts := []string{}
+	for _, p := range []struct {
+		label string
+		ids   []string
+	}{
+		{"added", d.Added},
+		{"removed", d.Removed},
+		{"changed", d.Changed},
+	} {
+		if len(p.ids) > 0 {
+			parts = append(parts, p.label+": "+strings.Join(p.ids, ", "))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func diffChecks(oldChecks, newChecks map[string]checkCommand) checksDiff {
+	d := checksDiff{}
+
+	for id, check := range newChecks {
+		oldCheck, ok := oldChecks[id]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, id)
+		case !reflect.DeepEqual(oldCheck, check):
+			d.Changed = append(d.Changed, id)
+		}
+	}
+
+	for id := range oldChecks {
+		if _, ok := newChecks[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+
+	return d
+}
+
+// reloadChecks reads and validates the check definitions and replaces
+// the currently active checks with them. If the definitions are invalid
+// the active checks are kept.
+func reloadChecks(reason string) error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	newChecks, err := loadChecks()
+	if err != nil {
+		return err
+	}
+
+	checksLock.Lock()
+	diff := diffChecks(checks, newChecks)
+	checks = newChecks
+	checksLock.Unlock()
+
+	if len(diff.Removed) > 0 {
+		forgetRemovedChecks(diff.Removed)
+	}
+
+	if !diff.isEmpty() {
+		logger.WithFields(logFields{"reason": reason, "diff": diff.String()}).Infof("Reloaded checks")
+	}
+
+	return nil
+}
+
+// forgetRemovedChecks discards the results of checks which are no longer
+// defined as they must not influence the health anymore. If this changes
+// the health of the instance the change is reported like after a check
+// execution.
+func forgetRemovedChecks(ids []string) {
+	var (
+		causeID string
+		cause   checkResult
+	)
+
+	checkResultsLock.Lock()
+	for _, id := range ids {
+		if cr, ok := checkResults[id]; ok {
+			if _, unhealthy := cr.state(); unhealthy || causeID == "" {
+				causeID, cause = id, *cr
+			}
+		}
+
+		delete(checkResults, id)
+		forgetCheckMetrics(id)
+		forgetHistory(id)
+	}
+
+	healthy := isHealthy()
+	verdictChanged := healthy != lastVerdictHealthy
+	lastVerdictHealthy = healthy
+	recordHealth(healthy)
+	checkResultsLock.Unlock()
+
+	stateSnapshot.markChanged()
+
+	if verdictChanged {
+		notifyWebhooks(newVerdictEvent(healthy, causeID, cause))
+		reportHealth(healthy)
+	}
+}
+
+func reloadOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	for range sigs {
+		if err := reloadChecks("SIGHUP"); err != nil {
+			logger.WithFields(logFields{"error": err}).Errorf("Unable to reload checks")
+		}
+	}
+}
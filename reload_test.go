@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestDiffChecks(t *testing.T) {
+	oldChecks := map[string]checkCommand{
+		"kept":    {Name: "kept", Command: commandLine{Script: "true"}},
+		"changed": {Name: "changed", Command: commandLine{Script: "true"}},
+		"removed": {Name: "removed", Command: commandLine{Script: "true"}},
+	}
+	newChecks := map[string]checkCommand{
+		"kept":    {Name: "kept", Command: commandLine{Script: "true"}},
+		"changed": {Name: "changed", Command: commandLine{Script: "false"}},
+		"b_added": {Name: "b", Command: commandLine{Script: "true"}},
+		"a_added": {Name: "a", Command: commandLine{Script: "true"}},
+	}
+
+	d := diffChecks(oldChecks, newChecks)
+	expect := checksDiff{
+		Added:   []string{"a_added", "b_added"},
+		Removed: []string{"removed"},
+		Changed: []string{"changed"},
+	}
+	if !reflect.DeepEqual(d, expect) {
+		t.Fatalf("Unexpected diff: %+v", d)
+	}
+	if s := d.String(); s != "added: a_added, b_added; removed: removed; changed: changed" {
+		t.Errorf("Unexpected diff description: %s", s)
+	}
+
+	if d := diffChecks(oldChecks, oldChecks); !d.isEmpty() || d.String() != "no changes" {
+		t.Errorf("Expected empty diff, got %+v", d)
+	}
+}
+
+func TestReloadChecks(t *testing.T) {
+	reg := setupTestChecks(t, map[string]checkCommand{})
+
+	defs := filepath.Join(t.TempDir(), "checks.yml")
+	cfg.CheckDefinitionsFiles = []string{defs}
+	cfg.TemplateDefinitions = false
+	cfg.DuplicateChecks = duplicateChecksOverride
+
+	write := func(content string) {
+		if err := ioutil.WriteFile(defs, []byte(content), 0644); err != nil {
+			t.Fatalf("Writing definitions failed: %s", err)
+		}
+	}
+
+	write("ok:\n  name: ok\n  command: \"true\"\nbad:\n  name: bad\n  command: \"false\"\n")
+	if err := reloadChecks("test"); err != nil {
+		t.Fatalf("Loading checks failed: %s", err)
+	}
+	if _, ok := getCheck("bad"); !ok {
+		t.Fatalf("Check was not loaded")
+	}
+
+	checkResultsLock.Lock()
+	checkResults["bad"] = &checkResult{Check: checks["bad"], Streak: cfg.UnhealthyThreshold}
+	checkResults["ok"] = &checkResult{Check: checks["ok"], IsSuccess: true, Streak: 1}
+	lastVerdictHealthy = false
+	recordHealth(false)
+	checkResultsLock.Unlock()
+
+	write("ok: {name: ok, command: [\"\"]}\n")
+	if err := reloadChecks("test"); err == nil {
+		t.Fatalf("Invalid definitions were accepted")
+	}
+	if _, ok := getCheck("bad"); !ok {
+		t.Fatalf("Active checks were replaced by invalid definitions")
+	}
+
+	write("ok:\n  name: ok\n  command: \"true\"\n")
+	if err := reloadChecks("test"); err != nil {
+		t.Fatalf("Reloading checks failed: %s", err)
+	}
+
+	checkResultsLock.RLock()
+	_, hasResult := checkResults["bad"]
+	healthy := lastVerdictHealthy
+	checkResultsLock.RUnlock()
+
+	if hasResult {
+		t.Errorf("Result of removed check was kept")
+	}
+	if !healthy {
+		t.Errorf("Verdict was not recomputed after removing the failing check")
+	}
+	if m := gatherMetric(t, reg, "test_healthy", nil); m == nil || m.GetGauge().GetValue() != 1 {
+		t.Errorf("Expected health gauge to be updated, got %v", m)
+	}
+}
+
+func TestRemovedCheckDuringPendingRun(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{
+		"a": {Name: "a", Command: commandLine{Script: "true"}},
+	})
+
+	run := runCheck("a", 200*time.Millisecond)
+
+	checksLock.Lock()
+	checks = map[string]checkCommand{}
+	checksLock.Unlock()
+	forgetRemovedChecks([]string{"a"})
+
+	select {
+	case <-run.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Pending run did not finish")
+	}
+
+	checkResultsLock.RLock()
+	defer checkResultsLock.RUnlock()
+	if cr, ok := checkResults["a"]; ok {
+		t.Errorf("Result of removed check was registered: %+v", cr)
+	}
+}
+
+func TestRemovedCheckDuringExecution(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{
+		"a": {Name: "a", Command: commandLine{Script: "sleep 0.2"}},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		executeAndRegisterCheck(context.Background(), "a")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	checksLock.Lock()
+	checks = map[string]checkCommand{}
+	checksLock.Unlock()
+	forgetRemovedChecks([]string{"a"})
+	<-done
+
+	checkResultsLock.RLock()
+	defer checkResultsLock.RUnlock()
+	if cr, ok := checkResults["a"]; ok {
+		t.Errorf("Result of check removed during its execution was registered: %+v", cr)
+	}
+}
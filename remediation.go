@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// remediation describes a command to fix a failing check before the
+// failure counts towards the unhealthy threshold
+type remediation struct {
+	Command     commandLine   `yaml:"command"`
+	After       int64         `yaml:"after"`
+	Cooldown    time.Duration `yaml:"cooldown"`
+	MaxAttempts int           `yaml:"max-attempts"`
+}
+
+// remediationState tracks the remediation attempts of the current streak
+// of failures
+type remediationState struct {
+	Attempts   int       `json:"attempts"`
+	Successes  int64     `json:"successes"`
+	LastRun    time.Time `json:"last_run"`
+	LastError  string    `json:"last_error,omitempty"`
+	LastOutput string    `json:"last_output,omitempty"`
+}
+
+func (r remediationState) String(rem *remediation) string {
+	maxAttempts := 0
+	if rem != nil {
+		maxAttempts = rem.MaxAttempts
+	}
+
+	result := "succeeded"
+	if r.LastError != "" {
+		result = "failed"
+	}
+	return fmt.Sprintf("remediation %d/%d %s", r.Attempts, maxAttempts, result)
+}
+
+func (r *remediation) validate() error {
+	if r == nil {
+		return nil
+	}
+
+	if r.Command.isEmpty() {
+		return errors.New("no command given")
+	}
+	if r.After < 1 {
+		r.After = 1
+	}
+	if r.MaxAttempts < 1 {
+		r.MaxAttempts = 1
+	}
+	return nil
+}
+
+// hasBudget reports whether there are remediation attempts left, failures
+// do not count towards the unhealthy threshold while this is the case
+func (r *remediation) hasBudget(state remediationState) bool {
+	return r != nil && state.Attempts < r.MaxAttempts
+}
+
+// isDue reports whether the remediation should be executed now
+func (r *remediation) isDue(streak int64, state remediationState) bool {
+	return r.hasBudget(state) &&
+		streak >= r.After &&
+		time.Since(state.LastRun) >= r.Cooldown
+}
+
+// remediateCheck executes the remediation command of the check using the
+// same settings (shell, environment, user, limits) as the check itself
+func remediateCheck(checkID string, check checkCommand) checkResult {
+	logger.WithFields(logFields{"check_id": checkID}).Infof("Check failed, executing remediation")
+
+	remCheck := check
+	remCheck.Command = check.Remediate.Command
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout())
+	defer cancel()
+
+	start := time.Now()
+	output := newOutputTail(maxCapturedOutput)
+	_, err := runCheckCommand(ctx, checkID+":remediate", remCheck, output)
+
+	checkResultsLock.Lock()
+	defer checkResultsLock.Unlock()
+
+	cr, ok := checkResults[checkID]
+	if _, defined := getCheck(checkID); !ok || !defined {
+		// Check was removed while being remediated
+		return checkResult{Check: check}
+	}
+
+	cr.Remediation.Attempts++
+	cr.Remediation.LastRun = start
+	cr.Remediation.LastOutput = output.String()
+	cr.Remediation.LastError = ""
+
+	if err != nil {
+		cr.Remediation.LastError = err.Error()
+		remediationRuns.WithLabelValues(checkID, "failure").Inc()
+		logger.WithFields(logFields{"check_id": checkID, "attempt": cr.Remediation.Attempts, "max_attempts": check.Remediate.MaxAttempts, "error": err}).Warnf("Remediation of check failed")
+	} else {
+		cr.Remediation.Successes++
+		remediationRuns.WithLabelValues(checkID, "success").Inc()
+		logger.WithFields(logFields{"check_id": checkID, "attempt": cr.Remediation.Attempts, "max_attempts": check.Remediate.MaxAttempts}).Infof("Remediation of check succeeded")
+	}
+
+	stateSnapshot.markChanged()
+
+	return *cr
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRemediationBudget(t *testing.T) {
+	rem := &remediation{Command: commandLine{Script: "true"}, After: 2, Cooldown: time.Minute, MaxAttempts: 2}
+
+	for name, tc := range map[string]struct {
+		rem       *remediation
+		streak    int64
+		state     remediationState
+		hasBudget bool
+		isDue     bool
+	}{
+		"no remediation":     {rem: nil, streak: 5, hasBudget: false, isDue: false},
+		"below after":        {rem: rem, streak: 1, hasBudget: true, isDue: false},
+		"reached after":      {rem: rem, streak: 2, hasBudget: true, isDue: true},
+		"in cooldown":        {rem: rem, streak: 3, state: remediationState{Attempts: 1, LastRun: time.Now()}, hasBudget: true, isDue: false},
+		"after cooldown":     {rem: rem, streak: 3, state: remediationState{Attempts: 1, LastRun: time.Now().Add(-2 * time.Minute)}, hasBudget: true, isDue: true},
+		"attempts exhausted": {rem: rem, streak: 5, state: remediationState{Attempts: 2}, hasBudget: false, isDue: false},
+	} {
+		if b := tc.rem.hasBudget(tc.state); b != tc.hasBudget {
+			t.Errorf("%s: Expected hasBudget to be %v", name, tc.hasBudget)
+		}
+		if d := tc.rem.isDue(tc.streak, tc.state); d != tc.isDue {
+			t.Errorf("%s: Expected isDue to be %v", name, tc.isDue)
+		}
+	}
+}
+
+func TestRemediationValidate(t *testing.T) {
+	rem := &remediation{Command: commandLine{Script: "true"}}
+	if err := rem.validate(); err != nil || rem.After != 1 || rem.MaxAttempts != 1 {
+		t.Errorf("Unexpected defaults after validation: %+v (%v)", rem, err)
+	}
+
+	if err := (&remediation{}).validate(); err == nil {
+		t.Errorf("Remediation without command was accepted")
+	}
+	if err := (*remediation)(nil).validate(); err != nil {
+		t.Errorf("Missing remediation caused an error: %s", err)
+	}
+}
+
+func TestUncountedStreak(t *testing.T) {
+	for name, tc := range map[string]struct {
+		result    checkResult
+		state     string
+		unhealthy bool
+	}{
+		"passing":                 {result: checkResult{IsSuccess: true, Streak: 10}, state: "PASS"},
+		"below threshold":         {result: checkResult{Streak: 4}, state: "CRIT"},
+		"reached threshold":       {result: checkResult{Streak: 5}, state: "CRIT", unhealthy: true},
+		"remediating":             {result: checkResult{Streak: 6, UncountedStreak: 3}, state: "CRIT"},
+		"threshold after uncount": {result: checkResult{Streak: 8, UncountedStreak: 3}, state: "CRIT", unhealthy: true},
+		"warn only":               {result: checkResult{Check: checkCommand{WarnOnly: true}, Streak: 10}, state: "WARN"},
+	} {
+		cfg.UnhealthyThreshold = 5
+		state, unhealthy := tc.result.state()
+		if state != tc.state || unhealthy != tc.unhealthy {
+			t.Errorf("%s: Unexpected state %s (unhealthy %v)", name, state, unhealthy)
+		}
+	}
+}
+
+func TestRemediationDefersUnhealthy(t *testing.T) {
+	rem := &remediation{Command: commandLine{Script: "true"}, After: 1, MaxAttempts: 2}
+	setupTestChecks(t, map[string]checkCommand{
+		"docker": {Name: "docker", Command: commandLine{Script: "false"}, Remediate: rem},
+	})
+	cfg.UnhealthyThreshold = 2
+
+	for i, expect := range []struct {
+		streak, uncounted int64
+		attempts          int
+		unhealthy         bool
+	}{
+		{streak: 1, uncounted: 1, attempts: 1},
+		{streak: 2, uncounted: 2, attempts: 2},
+		{streak: 3, uncounted: 2, attempts: 2},
+		{streak: 4, uncounted: 2, attempts: 2, unhealthy: true},
+	} {
+		cr := executeAndRegisterCheck(context.Background(), "docker")
+		_, unhealthy := cr.state()
+		if cr.Streak != expect.streak || cr.UncountedStreak != expect.uncounted || cr.Remediation.Attempts != expect.attempts || unhealthy != expect.unhealthy {
+			t.Errorf("Run %d: Unexpected result: streak %d, uncounted %d, attempts %d, unhealthy %v",
+				i+1, cr.Streak, cr.UncountedStreak, cr.Remediation.Attempts, unhealthy)
+		}
+	}
+
+	checksLock.Lock()
+	checks["docker"] = checkCommand{Name: "docker", Command: commandLine{Script: "true"}, Remediate: rem}
+	checksLock.Unlock()
+
+	cr := executeAndRegisterCheck(context.Background(), "docker")
+	if !cr.IsSuccess || cr.UncountedStreak != 0 || cr.Remediation.Attempts != 0 || cr.Remediation.Successes != 2 {
+		t.Errorf("Remediation state was not reset after passing: %+v", cr)
+	}
+}
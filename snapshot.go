@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// snapshotWriter persists snapshots of in-memory data like the check
+// results to a file. Changes are signalled without blocking the check
+// execution and written one after another by a single writer.
+type snapshotWriter struct {
+	// kind describes the data in log messages
+	kind    string
+	path    *string
+	version int
+	marshal func() ([]byte, error)
+	changed chan struct{}
+}
+
+func newSnapshotWriter(kind string, path *string, version int, marshal func() ([]byte, error)) *snapshotWriter {
+	return &snapshotWriter{
+		kind:    kind,
+		path:    path,
+		version: version,
+		marshal: marshal,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (s *snapshotWriter) markChanged() {
+	if *s.path == "" {
+		return
+	}
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+		// Snapshot is already pending
+	}
+}
+
+// run writes a snapshot after every change until the process exits
+func (s *snapshotWriter) run() {
+	for range s.changed {
+		if err := s.write(); err != nil {
+			logger.WithFields(logFields{"error": err}).Errorf("Unable to write %s file", s.kind)
+		}
+	}
+}
+
+func (s *snapshotWriter) write() error {
+	raw, err := s.marshal()
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(*s.path, raw)
+}
+
+// read loads the last snapshot into v and reports whether there was a
+// snapshot of the supported version
+func (s *snapshotWriter) read(v interface{}) (bool, error) {
+	raw, err := ioutil.ReadFile(*s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	header := struct {
+		Version int `json:"version"`
+	}{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return false, err
+	}
+
+	if header.Version != s.version {
+		logger.WithFields(logFields{"version": header.Version}).Warnf("Ignoring %s file with unsupported version", s.kind)
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, v)
+}
+
+// writeFileAtomic replaces the file with the given content without
+// leaving a partially written file behind when the daemon is killed
+func writeFileAtomic(path string, raw []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
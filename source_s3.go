@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// newS3Source creates a source for s3://bucket/key URLs fetching the
+// object through the S3 REST API using signed requests
+func newS3Source(u *url.URL) (definitionSource, error) {
+	bucket := u.Host
+	key := strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, errors.New("S3 URLs need to have the format s3://<bucket>/<key>")
+	}
+
+	region, err := awsRegion()
+	if err != nil {
+		return nil, err
+	}
+
+	var objectURL string
+	if cfg.S3Endpoint != "" {
+		objectURL = strings.TrimRight(cfg.S3Endpoint, "/") + "/" + bucket + "/" + key
+	} else {
+		objectURL = "https://" + bucket + ".s3." + region + ".amazonaws.com/" + key
+	}
+
+	return &remoteDefinitionsFetcher{
+		url: objectURL,
+		sign: func(req *http.Request) error {
+			creds, err := awsCredentials()
+			if err != nil {
+				return err
+			}
+			signV4(req, nil, creds, region, "s3", time.Now())
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestS3SourceWithInstanceCredentials(t *testing.T) {
+	imds := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			res.Write([]byte("token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "token":
+			res.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			res.Write([]byte("checks-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/checks-role":
+			res.Write([]byte(`{
+				"AccessKeyId": "ASIAEXAMPLE",
+				"SecretAccessKey": "secret",
+				"Token": "session-token",
+				"Expiration": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"
+			}`))
+		default:
+			http.NotFound(res, r)
+		}
+	}))
+	defer imds.Close()
+
+	s3 := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/checks-bucket/prod/checks.yml" {
+			http.NotFound(res, r)
+			return
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "session-token" {
+			t.Errorf("Session token of instance credentials was not sent")
+		}
+
+		// Sign the received request again to verify the signature
+		signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if err != nil {
+			t.Errorf("Request has invalid date: %s", err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		expected, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+		signV4(expected, nil, awsCredentialSet{
+			AccessKeyID:     "ASIAEXAMPLE",
+			SecretAccessKey: "secret",
+			Token:           "session-token",
+		}, "eu-central-1", "s3", signedAt)
+
+		if auth := r.Header.Get("Authorization"); auth != expected.Header.Get("Authorization") {
+			t.Errorf("Unexpected signature:\n%s\nexpected:\n%s", auth, expected.Header.Get("Authorization"))
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		res.Write([]byte("foo: {}"))
+	}))
+	defer s3.Close()
+
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		if v, ok := os.LookupEnv(env); ok {
+			defer os.Setenv(env, v)
+		}
+		os.Unsetenv(env)
+	}
+
+	oldClient := metadataClient
+	metadataClient = &imdsClient{}
+	instanceCredentials = nil
+	defer func() {
+		metadataClient = oldClient
+		instanceCredentials = nil
+	}()
+
+	cfg.AWSRegion = "eu-central-1"
+	cfg.IMDSEndpoint = imds.URL
+	cfg.S3Endpoint = s3.URL + "/"
+	cfg.DefinitionsFetchTimeout = time.Second
+	cfg.DefinitionsFetchRetries = 0
+	defer func() { cfg.S3Endpoint = "" }()
+
+	body, err := fetchFromSource("s3://checks-bucket/prod/checks.yml")
+	if err != nil {
+		t.Fatalf("Fetching from S3 failed: %s", err)
+	}
+	if string(body) != "foo: {}" {
+		t.Errorf("Unexpected body: %q", body)
+	}
+
+	if _, err := fetchFromSource("s3://checks-bucket/missing.yml"); err == nil {
+		t.Errorf("Fetching a missing object did not return an error")
+	}
+}
+
+func TestS3SourceURL(t *testing.T) {
+	cfg.AWSRegion = "eu-west-1"
+	cfg.S3Endpoint = ""
+
+	for _, in := range []string{"s3://bucket", "s3:///key"} {
+		if _, err := sourceFor(in); err == nil {
+			t.Errorf("Expected invalid URL %s to be rejected", in)
+		}
+	}
+
+	src, err := sourceFor("s3://bucket/dir/checks.yml")
+	if err != nil {
+		t.Fatalf("Creating source failed: %s", err)
+	}
+	if u := src.(*remoteDefinitionsFetcher).url; u != "https://bucket.s3.eu-west-1.amazonaws.com/dir/checks.yml" {
+		t.Errorf("Unexpected object URL: %s", u)
+	}
+}
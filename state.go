@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const stateFileVersion = 1
+
+type stateFile struct {
+	Version int                       `json:"version"`
+	SavedAt time.Time                 `json:"saved_at"`
+	Checks  map[string]checkStateItem `json:"checks"`
+}
+
+type checkStateItem struct {
+	DefinitionHash string    `json:"definition_hash"`
+	IsSuccess      bool      `json:"is_success"`
+	Streak         int64     `json:"streak"`
+	LastRun        time.Time `json:"last_run"`
+	LastError      string    `json:"last_error,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+
+	UncountedStreak int64            `json:"uncounted_streak,omitempty"`
+	Remediation     remediationState `json:"remediation"`
+}
+
+var stateSnapshot = newSnapshotWriter("state", &cfg.StateFile, stateFileVersion, marshalState)
+
+// definitionHash identifies the definition of a check to discard state
+// of checks whose definition changed while the daemon was not running
+func (c checkCommand) definitionHash() string {
+	c.Source = ""
+	raw, _ := json.Marshal(c)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func marshalState() ([]byte, error) {
+	state := stateFile{
+		Version: stateFileVersion,
+		SavedAt: time.Now(),
+		Checks:  map[string]checkStateItem{},
+	}
+
+	checkResultsLock.RLock()
+	for id, cr := range checkResults {
+		state.Checks[id] = checkStateItem{
+			DefinitionHash: cr.Check.definitionHash(),
+			IsSuccess:      cr.IsSuccess,
+			Streak:         cr.Streak,
+			LastRun:        cr.LastRun,
+			LastError:      cr.LastError,
+			Reason:         cr.Reason,
+
+			UncountedStreak: cr.UncountedStreak,
+			Remediation:     cr.Remediation,
+		}
+	}
+	checkResultsLock.RUnlock()
+
+	return json.Marshal(state)
+}
+
+// restoreState loads the results stored in the state file and derives the
+// verdict from them. Results older than the maximum state age and results
+// of checks which are no longer defined or whose definition changed are
+// discarded.
+func restoreState() error {
+	state := stateFile{}
+	if ok, err := stateSnapshot.read(&state); !ok || err != nil {
+		return err
+	}
+
+	checkResultsLock.Lock()
+	defer checkResultsLock.Unlock()
+
+	restored := 0
+	for id, item := range state.Checks {
+		check, ok := getCheck(id)
+		switch {
+		case !ok:
+			continue
+		case check.definitionHash() != item.DefinitionHash:
+			continue
+		case time.Since(item.LastRun) > cfg.StateMaxAge:
+			continue
+		}
+
+		cr := &checkResult{
+			Check:     check,
+			IsSuccess: item.IsSuccess,
+			Streak:    item.Streak,
+			LastRun:   item.LastRun,
+			LastError: item.LastError,
+			Reason:    item.Reason,
+
+			UncountedStreak: item.UncountedStreak,
+			Remediation:     item.Remediation,
+		}
+
+		checkResults[id] = cr
+		recordCheckState(id, *cr)
+		restored++
+	}
+
+	// The verdict held before the restart is not reported as a change
+	lastVerdictHealthy = isHealthy()
+	recordHealth(lastVerdictHealthy)
+
+	logger.WithFields(logFields{"restored": restored, "total": len(state.Checks)}).Infof("Restored state of checks from state file")
+	return nil
+}
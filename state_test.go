@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatePersistence(t *testing.T) {
+	reg := setupTestChecks(t, map[string]checkCommand{
+		"docker":  {Name: "Docker is running", Command: commandLine{Script: "true"}},
+		"changed": {Name: "Changed", Command: commandLine{Script: "true"}},
+		"stale":   {Name: "Stale", Command: commandLine{Script: "true"}},
+	})
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	cfg.StateMaxAge = time.Hour
+	defer func() { cfg.StateFile = "" }()
+
+	lastRun := time.Now().Add(-time.Minute).Round(time.Second)
+
+	checkResultsLock.Lock()
+	checkResults["docker"] = &checkResult{Check: checks["docker"], Streak: 5, LastRun: lastRun, LastError: "exit status 1", Reason: reasonTimeout}
+	checkResults["changed"] = &checkResult{Check: checks["changed"], IsSuccess: true, Streak: 1, LastRun: lastRun}
+	checkResults["stale"] = &checkResult{Check: checks["stale"], IsSuccess: true, Streak: 1, LastRun: time.Now().Add(-2 * time.Hour)}
+	checkResults["removed"] = &checkResult{Check: checkCommand{Name: "Removed"}, Streak: 1, LastRun: lastRun}
+	checkResultsLock.Unlock()
+
+	if err := stateSnapshot.write(); err != nil {
+		t.Fatalf("Writing state failed: %s", err)
+	}
+
+	checksLock.Lock()
+	checks["changed"] = checkCommand{Name: "Changed", Command: commandLine{Script: "false"}}
+	checksLock.Unlock()
+
+	checkResultsLock.Lock()
+	checkResults = map[string]*checkResult{}
+	checkResultsLock.Unlock()
+
+	if err := restoreState(); err != nil {
+		t.Fatalf("Restoring state failed: %s", err)
+	}
+
+	checkResultsLock.RLock()
+	defer checkResultsLock.RUnlock()
+
+	if len(checkResults) != 1 {
+		t.Fatalf("Expected only the result of the unchanged check to be restored, got %v", checkResults)
+	}
+
+	if lastVerdictHealthy {
+		t.Errorf("Verdict was not derived from the restored failing check")
+	}
+	if m := gatherMetric(t, reg, "test_healthy", nil); m == nil || m.GetGauge().GetValue() != 0 {
+		t.Errorf("Expected health gauge to reflect the restored verdict, got %v", m)
+	}
+
+	cr := checkResults["docker"]
+	if cr == nil || cr.IsSuccess || cr.Streak != 5 || !cr.LastRun.Equal(lastRun) || cr.LastError != "exit status 1" || cr.Reason != reasonTimeout {
+		t.Errorf("Unexpected restored result: %+v", cr)
+	}
+	if cr != nil && cr.Check.Name != "Docker is running" {
+		t.Errorf("Restored result is not attached to the current definition: %+v", cr.Check)
+	}
+}
+
+func TestRestoreStateFiles(t *testing.T) {
+	setupTestChecks(t, map[string]checkCommand{})
+	dir := t.TempDir()
+	defer func() { cfg.StateFile = "" }()
+
+	cfg.StateFile = filepath.Join(dir, "missing.json")
+	if err := restoreState(); err != nil {
+		t.Errorf("Missing state file caused an error: %s", err)
+	}
+
+	for name, content := range map[string]string{
+		"corrupt.json":   `{"version": 1, "checks": {`,
+		"truncated.json": ``,
+	} {
+		cfg.StateFile = filepath.Join(dir, name)
+		ioutil.WriteFile(cfg.StateFile, []byte(content), 0600)
+
+		if err := restoreState(); err == nil {
+			t.Errorf("Expected %s to cause an error", name)
+		}
+	}
+
+	cfg.StateFile = filepath.Join(dir, "future.json")
+	ioutil.WriteFile(cfg.StateFile, []byte(`{"version": 2, "checks": {"docker": {}}}`), 0600)
+	if err := restoreState(); err != nil {
+		t.Errorf("Unsupported version caused an error: %s", err)
+	}
+	if len(checkResults) != 0 {
+		t.Errorf("State of unsupported version was restored: %v", checkResults)
+	}
+}
+
+func TestRestoreRemediationState(t *testing.T) {
+	rem := &remediation{Command: commandLine{Script: "true"}, After: 1, MaxAttempts: 3}
+	setupTestChecks(t, map[string]checkCommand{
+		"docker": {Name: "Docker is running", Command: commandLine{Script: "false"}, Remediate: rem},
+	})
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+	cfg.StateMaxAge = time.Hour
+	cfg.UnhealthyThreshold = 5
+	defer func() { cfg.StateFile = "" }()
+
+	remediatedAt := time.Now().Add(-time.Minute).Round(time.Second)
+
+	checkResultsLock.Lock()
+	checkResults["docker"] = &checkResult{
+		Check:           checks["docker"],
+		Streak:          6,
+		UncountedStreak: 2,
+		LastRun:         time.Now(),
+		Remediation: remediationState{
+			Attempts:   2,
+			Successes:  1,
+			LastRun:    remediatedAt,
+			LastError:  "exit status 1",
+			LastOutput: "restarting docker",
+		},
+	}
+	checkResultsLock.Unlock()
+
+	if err := stateSnapshot.write(); err != nil {
+		t.Fatalf("Writing state failed: %s", err)
+	}
+
+	checkResultsLock.Lock()
+	checkResults = map[string]*checkResult{}
+	checkResultsLock.Unlock()
+
+	if err := restoreState(); err != nil {
+		t.Fatalf("Restoring state failed: %s", err)
+	}
+
+	checkResultsLock.RLock()
+	defer checkResultsLock.RUnlock()
+
+	cr := checkResults["docker"]
+	if cr == nil {
+		t.Fatalf("Result was not restored")
+	}
+	if cr.UncountedStreak != 2 {
+		t.Errorf("Uncounted streak was not restored: %d", cr.UncountedStreak)
+	}
+	if r := cr.Remediation; r.Attempts != 2 || r.Successes != 1 || !r.LastRun.Equal(remediatedAt) || r.LastError != "exit status 1" || r.LastOutput != "restarting docker" {
+		t.Errorf("Unexpected restored remediation state: %+v", r)
+	}
+	if _, unhealthy := cr.state(); unhealthy {
+		t.Errorf("Check being remediated is unhealthy after restoring its state")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+)
+
+const (
+	webhookEventCheckState = "check_state_changed"
+	webhookEventVerdict    = "health_changed"
+
+	webhookTimeout     = 10 * time.Second
+	webhookOutputBytes = 1024
+	webhookQueueSize   = 100
+)
+
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Instance  string    `json:"instance"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Overall health of the instance, only set for health change events
+	Healthy *bool `json:"healthy,omitempty"`
+
+	CheckID   string `json:"check_id"`
+	CheckName string `json:"check_name"`
+	OldState  string `json:"old_state,omitempty"`
+	NewState  string `json:"new_state"`
+	Streak    int64  `json:"streak"`
+	Reason    string `json:"reason,omitempty"`
+	Output    string `json:"output,omitempty"`
+}
+
+func newWebhookEvent(event, checkID string, cr checkResult) webhookEvent {
+	hostname, _ := os.Hostname()
+	state, _ := cr.state()
+
+	output := cr.LastOutput
+	if len(output) > webhookOutputBytes {
+		output = output[len(output)-webhookOutputBytes:]
+	}
+
+	return webhookEvent{
+		Event:     event,
+		Instance:  hostname,
+		Timestamp: time.Now(),
+		CheckID:   checkID,
+		CheckName: cr.Check.Name,
+		NewState:  state,
+		Streak:    cr.Streak,
+		Reason:    cr.Reason,
+		Output:    output,
+	}
+}
+
+func newCheckStateEvent(checkID, oldState, newState string, cr checkResult) webhookEvent {
+	e := newWebhookEvent(webhookEventCheckState, checkID, cr)
+	e.OldState = oldState
+	e.NewState = newState
+	return e
+}
+
+// newVerdictEvent creates an event for a change of the overall health
+// containing the check whose result caused the change
+func newVerdictEvent(healthy bool, checkID string, cr checkResult) webhookEvent {
+	e := newWebhookEvent(webhookEventVerdict, checkID, cr)
+	e.Healthy = &healthy
+	return e
+}
+
+// Summary returns a human readable description of the event
+func (e webhookEvent) Summary() string {
+	if e.Healthy != nil {
+		verdict := "unhealthy"
+		if *e.Healthy {
+			verdict = "healthy"
+		}
+		return fmt.Sprintf("Instance %s is now %s (caused by check %q: %s)", e.Instance, verdict, e.CheckName, e.NewState)
+	}
+
+	return fmt.Sprintf("Check %q on %s changed from %s to %s (streak %d)", e.CheckName, e.Instance, e.OldState, e.NewState, e.Streak)
+}
+
+// webhookQueue delivers the events to a single URL one after another to
+// keep them in the order they occurred
+type webhookQueue struct {
+	url    string
+	events chan webhookEvent
+}
+
+var (
+	webhookQueues []*webhookQueue
+	// webhookTemplate is set if the payload is rendered using a template
+	webhookTemplate *template.Template
+)
+
+// initWebhooks validates the payload format and starts a queue for each
+// configured URL
+func initWebhooks() error {
+	webhookTemplate = nil
+	if cfg.WebhookTemplate != "" {
+		rawTpl, err := ioutil.ReadFile(cfg.WebhookTemplate)
+		if err != nil {
+			return err
+		}
+
+		webhookTemplate, err = template.New("webhook").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				raw, err := json.Marshal(v)
+				return string(raw), err
+			},
+		}).Parse(string(rawTpl))
+		if err != nil {
+			return fmt.Errorf("Invalid webhook template: %s", err)
+		}
+	} else {
+		switch cfg.WebhookFormat {
+		case "generic", "slack":
+		default:
+			return fmt.Errorf("Unknown webhook format %q", cfg.WebhookFormat)
+		}
+	}
+
+	webhookQueues = nil
+	for _, url := range cfg.WebhookURLs {
+		if url == "" {
+			continue
+		}
+
+		q := &webhookQueue{url: url, events: make(chan webhookEvent, webhookQueueSize)}
+		go q.run()
+		webhookQueues = append(webhookQueues, q)
+	}
+
+	return nil
+}
+
+func (q *webhookQueue) run() {
+	for e := range q.events {
+		if err := sendWebhook(q.url, e); err != nil {
+			logger.WithFields(logFields{"url": q.url, "event": e.Event, "check_id": e.CheckID, "error": err}).Errorf("Unable to send webhook")
+		}
+	}
+}
+
+// notifyWebhooks queues the event for all configured webhooks to not
+// block the execution of the checks. If a webhook is unavailable for so
+// long its queue is full the event is dropped for that webhook.
+func notifyWebhooks(e webhookEvent) {
+	for _, q := range webhookQueues {
+		select {
+		case q.events <- e:
+		default:
+			logger.WithFields(logFields{"url": q.url, "event": e.Event, "check_id": e.CheckID}).Warnf("Webhook queue is full, dropping event")
+		}
+	}
+}
+
+func renderWebhookPayload(e webhookEvent) ([]byte, error) {
+	if webhookTemplate != nil {
+		buf := new(bytes.Buffer)
+		err := webhookTemplate.Execute(buf, e)
+		return buf.Bytes(), err
+	}
+
+	switch cfg.WebhookFormat {
+	case "slack":
+		text := e.Summary()
+		if e.Output != "" {
+			text += "\n```" + e.Output + "```"
+		}
+		return json.Marshal(map[string]string{"text": text})
+	case "generic":
+		return json.Marshal(e)
+	default:
+		return nil, fmt.Errorf("Unknown webhook format %q", cfg.WebhookFormat)
+	}
+}
+
+func sendWebhook(url string, e webhookEvent) error {
+	payload, err := renderWebhookPayload(e)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	for attempt := 0; attempt <= cfg.WebhookRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt - 1))
+		}
+
+		var resp *http.Response
+		resp, err = client.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return nil
+		}
+
+		err = fetchError{Resource: url, StatusCode: resp.StatusCode}
+		if !retryable(err) {
+			break
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSendWebhook(t *testing.T) {
+	var (
+		requests int
+		received webhookEvent
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			res.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("Webhook payload is no valid JSON: %s", err)
+		}
+	}))
+	defer srv.Close()
+
+	cfg.WebhookFormat = "generic"
+	cfg.WebhookRetries = 1
+
+	e := newCheckStateEvent("docker", "PASS", "CRIT", checkResult{
+		Check:  checkCommand{Name: "Docker is running"},
+		Streak: 1,
+	})
+
+	if err := sendWebhook(srv.URL, e); err != nil {
+		t.Fatalf("Sending webhook failed: %s", err)
+	}
+
+	if requests != 2 {
+		t.Fatalf("Failed webhook was not retried: %d requests", requests)
+	}
+
+	if received.CheckID != "docker" || received.OldState != "PASS" || received.NewState != "CRIT" {
+		t.Fatalf("Unexpected webhook payload: %+v", received)
+	}
+}
+
+func TestSlackWebhookPayload(t *testing.T) {
+	cfg.WebhookFormat = "slack"
+
+	healthy := false
+	payload, err := renderWebhookPayload(webhookEvent{
+		Instance:  "myhost",
+		Healthy:   &healthy,
+		CheckName: "Docker is running",
+		NewState:  "CRIT",
+	})
+	if err != nil {
+		t.Fatalf("Rendering payload failed: %s", err)
+	}
+
+	msg := map[string]string{}
+	json.Unmarshal(payload, &msg)
+
+	if expected := `Instance myhost is now unhealthy (caused by check "Docker is running": CRIT)`; msg["text"] != expected {
+		t.Fatalf("Unexpected slack message: %q", msg["text"])
+	}
+}
+
+func TestWebhookQueueOrder(t *testing.T) {
+	var (
+		requests int
+		received = make(chan string, 3)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			res.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		var e webhookEvent
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &e)
+		received <- e.NewState
+	}))
+	defer srv.Close()
+
+	cfg.WebhookFormat = "generic"
+	cfg.WebhookTemplate = ""
+	cfg.WebhookRetries = 1
+	cfg.WebhookURLs = []string{srv.URL}
+	defer func() { cfg.WebhookURLs = nil }()
+
+	if err := initWebhooks(); err != nil {
+		t.Fatalf("Initializing webhooks failed: %s", err)
+	}
+	queue := webhookQueues[0]
+	defer close(queue.events)
+	defer func() { webhookQueues = nil }()
+
+	for _, state := range []string{"WARN", "CRIT", "PASS"} {
+		notifyWebhooks(newCheckStateEvent("docker", "PASS", state, checkResult{}))
+	}
+
+	for _, expect := range []string{"WARN", "CRIT", "PASS"} {
+		select {
+		case state := <-received:
+			if state != expect {
+				t.Fatalf("Webhooks delivered out of order: got %s, expected %s", state, expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Webhook for %s was not delivered", expect)
+		}
+	}
+}
+
+func TestInitWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.tpl")
+	ioutil.WriteFile(valid, []byte(`{"text": {{ json .Summary }}}`), 0600)
+	invalid := filepath.Join(dir, "invalid.tpl")
+	ioutil.WriteFile(invalid, []byte(`{"text": {{ .Summary }`), 0600)
+
+	defer func() { cfg.WebhookTemplate = "" }()
+
+	for name, tc := range map[string]struct {
+		format, template string
+		valid            bool
+	}{
+		"generic":          {format: "generic", valid: true},
+		"slack":            {format: "slack", valid: true},
+		"unknown format":   {format: "teams"},
+		"template":         {format: "teams", template: valid, valid: true},
+		"invalid template": {format: "generic", template: invalid},
+		"missing template": {format: "generic", template: filepath.Join(dir, "missing.tpl")},
+	} {
+		cfg.WebhookFormat = tc.format
+		cfg.WebhookTemplate = tc.template
+
+		if err := initWebhooks(); (err == nil) != tc.valid {
+			t.Errorf("%s: Unexpected validation result: %v", name, err)
+		}
+	}
+
+	healthy := false
+	cfg.WebhookTemplate = valid
+	if err := initWebhooks(); err != nil {
+		t.Fatalf("Initializing webhooks failed: %s", err)
+	}
+	payload, err := renderWebhookPayload(webhookEvent{
+		Instance:  "myhost",
+		Healthy:   &healthy,
+		CheckName: "Docker is running",
+		NewState:  "CRIT",
+	})
+	if err != nil {
+		t.Fatalf("Rendering payload failed: %s", err)
+	}
+
+	msg := map[string]string{}
+	json.Unmarshal(payload, &msg)
+
+	if expected := `Instance myhost is now unhealthy (caused by check "Docker is running": CRIT)`; msg["text"] != expected {
+		t.Errorf("Unexpected templated payload: %s", payload)
+	}
+	webhookTemplate = nil
+}